@@ -0,0 +1,108 @@
+package main
+
+import "time"
+
+const (
+	// apiSchemaVersion is the version of the JSON schema returned by the repo API.
+	// Version 1 was the plain Object with Repo, Tag, Cover and Output, version 2 added the
+	// breakdown per package and file, version 3 added Status, Error and Percent
+	apiSchemaVersion = 3
+
+	// detailFiles is the `detail` query value which adds the per file coverage to the response
+	detailFiles = "files"
+)
+
+// RepoResponse is the JSON response with the coverage details of a repository.
+// Repo, Tag, Cover and Output are the same as in schema version 1, so clients reading only
+// those keep working.
+type RepoResponse struct {
+	SchemaVersion int
+
+	Repo   string
+	Tag    string
+	Cover  string
+	Output bool
+
+	// Ref is the branch, tag or commit requested, empty for the default branch
+	Ref string
+	// Commit is the SHA of the commit measured
+	Commit string
+
+	// Statements is the total number of statements of all packages
+	Statements int
+	// Covered is the number of statements covered by tests
+	Covered int
+	// Percent is the statement weighted coverage of all packages
+	Percent float64
+
+	// Mode is "module" or "gopath", depending on how the repository was measured
+	Mode string
+	// Status is the state of the cover run, e.g. "passed" or "tests_failed". If it timed
+	// out, Packages only holds the packages tested before, without their number of statements.
+	Status RunStatus
+	// Error is the error of a cover run which did not pass
+	Error string `json:",omitempty"`
+	// StartedAt and FinishedAt are the start and end times of the cover run, if it is done
+	StartedAt  *time.Time `json:",omitempty"`
+	FinishedAt *time.Time `json:",omitempty"`
+	// Stale is true if the result is older than an hour or the ref was pushed since, it is
+	// served while a cover run refreshes it. Age is the age of the result in seconds.
+	Stale bool  `json:",omitempty"`
+	Age   int64 `json:",omitempty"`
+
+	Packages []PackageCoverage
+	// Failures are the packages whose tests failed, with the failing tests
+	Failures []FailedPackage `json:",omitempty"`
+	// Tests are the test counts, durations and slowest tests of every package, only known
+	// with Go 1.10 and later
+	Tests *TestSummary `json:",omitempty"`
+	// Files is only set if the per file detail is requested
+	Files []FileCoverage `json:",omitempty"`
+}
+
+// newRepoResponse returns the API response for the given Object
+func newRepoResponse(obj *Object, detail string) *RepoResponse {
+	resp := &RepoResponse{
+		SchemaVersion: apiSchemaVersion,
+		Repo:          obj.Repo,
+		Tag:           obj.Tag,
+		Cover:         obj.Cover,
+		Output:        obj.Output,
+		Ref:           obj.Ref,
+		Commit:        obj.Commit,
+		Mode:          obj.Mode,
+		Status:        obj.Status,
+		Error:         obj.Error,
+		Packages:      obj.Packages,
+		Failures:      obj.Failures,
+		Tests:         obj.Tests,
+	}
+
+	if resp.Packages == nil {
+		resp.Packages = []PackageCoverage{}
+	}
+
+	resp.Statements, resp.Covered, resp.Percent = totalCoverage(resp.Packages)
+	// a result saved before the breakdown per package only has its percentage
+	if len(obj.Packages) == 0 {
+		resp.Percent = obj.Percent
+	}
+
+	if !obj.StartedAt.IsZero() {
+		resp.StartedAt, resp.FinishedAt = &obj.StartedAt, &obj.FinishedAt
+	}
+	// a queued or running repository has no result yet
+	if obj.Status != StatusQueued && obj.Status != StatusRunning {
+		now := time.Now()
+		resp.Stale, resp.Age = obj.stale(now), int64(obj.age(now)/time.Second)
+	}
+
+	if detail == detailFiles {
+		resp.Files = obj.Files
+		if resp.Files == nil {
+			resp.Files = []FileCoverage{}
+		}
+	}
+
+	return resp
+}
@@ -26,17 +26,16 @@
 			return;
 		}
 
-		const url = [baseURI, data.Repo + ".svg?style=flat&tag=" + data.Tag + "&d="].join("/");
+		const ref = data.Ref ? "&ref=" + encodeURIComponent(data.Ref) : "";
+
+		const url = [baseURI, data.Repo + ".svg?style=flat&tag=" + data.Tag + ref + "&d="].join("/");
 		$("#badge").attr("src", url + (new Date()).getTime());
 
-		const mdurl = ["https://cover.run/go", data.Repo + ".svg?style=flat&tag=" + data.Tag].join("/");
+		const mdurl = ["https://cover.run/go", data.Repo + ".svg?style=flat&tag=" + data.Tag + ref].join("/");
 
-		const params = jQuery.param({
-			tag: data.Tag,
-			repo: data.Repo
-		});
+		const reporturl = ["https://cover.run/go", data.Repo + ".html?tag=" + data.Tag + ref].join("/");
 
-		const bdg = "[![cover.run](" + mdurl + ")](https://cover.run/go?" + params + ")";
+		const bdg = "[![cover.run](" + mdurl + ")](" + reporturl + ")";
 
 		$("#mdbadge").text(bdg)
 		$("#details").text(data.Cover)
@@ -47,6 +46,30 @@
 		}
 	}
 
+	// running returns true if the coverage of the repository is not measured yet
+	function running(data) {
+		return data.Status == "queued" || data.Status == "running";
+	}
+
+	function loadVersions(selected) {
+		$.getJSON({
+			url: "/versions.json",
+			success: function (list) {
+				const dom = $("#tag");
+				dom.empty();
+				list.forEach(function (v) {
+					dom.append($("<option>").val(v.Name).text("Go " + v.Version));
+				});
+
+				list.forEach(function (v) {
+					if (selected && v.Aliases.indexOf(selected) > -1) {
+						dom.val(v.Name);
+					}
+				});
+			},
+		});
+	}
+
 	function getCoverage(repo, tag) {
 		if (!repo) {
 			return;
@@ -60,7 +83,7 @@
 			success: function (body) {
 				ldom.attr("class", "hidden");
 				showCoverage(body);
-				if (body.Cover.indexOf("queued") > -1 || body.Cover.indexOf("progress") > -1) {
+				if (running(body)) {
 					pollStatus(repo, tag);
 				}
 			},
@@ -80,11 +103,11 @@
 		$.getJSON({
 			url: baseURI + "/" + repo + ".json?tag=" + tag,
 			success: function (body) {
-				if (!body.Cover) {
+				if (!body.Status) {
 					return;
 				}
 
-				if (body.Cover.indexOf("queued") == -1 && body.Cover.indexOf("progress") == -1) {
+				if (!running(body)) {
 					bdom.attr("class", "hidden");
 					showCoverage(body);
 					return;
@@ -105,8 +128,10 @@
 	}
 
 	$(document).ready(function () {
-		var repo = getParameterByName("repo").trim();
-		var tag = getParameterByName("tag").trim();
+		var repo = (getParameterByName("repo") || "").trim();
+		var tag = (getParameterByName("tag") || "").trim();
+		loadVersions(tag);
+
 		if (!repo) {
 			repo = $("#repo").val().trim();
 		}
@@ -116,7 +141,6 @@
 				tag = $("#tag").val().trim();
 			}
 			$("#repo").val(repo);
-			$("#tag").val(tag);
 			getCoverage(repo, tag);
 		}
 
@@ -126,4 +150,4 @@
 			}
 		});
 	});
-})($);
\ No newline at end of file
+})($);
\ No newline at end of file
@@ -3,24 +3,76 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"strconv"
+	"regexp"
 	"strings"
 	"text/template"
-
-	"github.com/go-redis/cache"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
 	// Badge templates to generate badges
-	curveBadge = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient><clipPath id="a"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0h61v20H0z"/><path fill="{{.Color}}" d="M61 0h53v20H61z"/><path fill="url(#b)" d="M0 0h114v20H0z"/></g><g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="315" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="510">{{.Label}}</text><text x="315" y="140" transform="scale(.1)" textLength="510">{{.Label}}</text><text x="{{.StatusX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="">{{.Status}}</text><text x="{{.StatusX}}" y="140" transform="scale(.1)" textLength="">{{.Status}}</text></g> </svg>`
-	flatBadge  = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><g shape-rendering="crispEdges"><path fill="#555" d="M0 0h61v20H0z"/><path fill="{{.Color}}" d="M61 0h57v20H61z"/></g><g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="315" y="140" transform="scale(.1)" textLength="510">{{.Label}}</text><text x="{{.StatusX}}" y="140" transform="scale(.1)" textLength="">{{.Status}}</text></g> </svg>`
+	curveBadge = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient><clipPath id="a"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0h{{.LabelWidth}}v20H0z"/><path fill="{{.Color}}" d="M{{.LabelWidth}} 0h{{.StatusWidth}}v20H{{.LabelWidth}}z"/><path fill="url(#b)" d="M0 0h{{.Width}}v20H0z"/></g>{{if .Logo}}<image x="{{.LogoX}}" y="3" width="{{.LogoWidth}}" height="14" xlink:href="{{.Logo}}"/>{{end}}<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="{{.LabelX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.LabelX}}" y="140" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.StatusX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text><text x="{{.StatusX}}" y="140" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text></g> </svg>`
+	flatBadge  = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><g shape-rendering="crispEdges"><path fill="#555" d="M0 0h{{.LabelWidth}}v20H0z"/><path fill="{{.Color}}" d="M{{.LabelWidth}} 0h{{.StatusWidth}}v20H{{.LabelWidth}}z"/></g>{{if .Logo}}<image x="{{.LogoX}}" y="3" width="{{.LogoWidth}}" height="14" xlink:href="{{.Logo}}"/>{{end}}<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="{{.LabelX}}" y="140" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.StatusX}}" y="140" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text></g> </svg>`
+
+	// plastic, for-the-badge and social badge templates, following the shields.io styles
+	plasticBadge     = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="18"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#fff" stop-opacity=".7"/><stop offset=".1" stop-color="#aaa" stop-opacity=".1"/><stop offset=".9" stop-opacity=".3"/><stop offset="1" stop-opacity=".5"/></linearGradient><clipPath id="a"><rect width="{{.Width}}" height="18" rx="4" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0h{{.LabelWidth}}v18H0z"/><path fill="{{.Color}}" d="M{{.LabelWidth}} 0h{{.StatusWidth}}v18H{{.LabelWidth}}z"/><path fill="url(#b)" d="M0 0h{{.Width}}v18H0z"/></g>{{if .Logo}}<image x="{{.LogoX}}" y="2" width="{{.LogoWidth}}" height="14" xlink:href="{{.Logo}}"/>{{end}}<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="{{.LabelX}}" y="140" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.LabelX}}" y="130" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.StatusX}}" y="140" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text><text x="{{.StatusX}}" y="130" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text></g> </svg>`
+	forTheBadgeBadge = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="28"><g shape-rendering="crispEdges"><path fill="#555" d="M0 0h{{.LabelWidth}}v28H0z"/><path fill="{{.Color}}" d="M{{.LabelWidth}} 0h{{.StatusWidth}}v28H{{.LabelWidth}}z"/></g>{{if .Logo}}<image x="{{.LogoX}}" y="7" width="{{.LogoWidth}}" height="14" xlink:href="{{.Logo}}"/>{{end}}<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" text-rendering="geometricPrecision" font-size="100"><text x="{{.LabelX}}" y="175" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.StatusX}}" y="175" font-weight="bold" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text></g> </svg>`
+	socialBadge      = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><linearGradient id="a" x2="0" y2="100%"><stop offset="0" stop-color="#fcfcfc" stop-opacity="0"/><stop offset="1" stop-opacity=".1"/></linearGradient><g stroke="#d5d5d5"><rect stroke="none" fill="#fcfcfc" x=".5" y=".5" width="{{.LabelRectWidth}}" height="19" rx="2"/><rect x="{{.BubbleX}}" y=".5" width="{{.StatusWidth}}" height="19" rx="2" fill="#fafafa"/><rect x="{{.ArrowX}}" y="7.5" width=".5" height="5" stroke="#fafafa"/><path d="M{{.BubbleX}} 6.5l-3 3v1l3 3" fill="#fafafa"/></g>{{if .Logo}}<image x="{{.LogoX}}" y="3" width="{{.LogoWidth}}" height="14" xlink:href="{{.Logo}}"/>{{end}}<g fill="#333" text-anchor="middle" font-family="Helvetica Neue,Helvetica,Arial,sans-serif" text-rendering="geometricPrecision" font-weight="700" font-size="110"><rect stroke="#d5d5d5" fill="url(#a)" x=".5" y=".5" width="{{.LabelRectWidth}}" height="19" rx="2"/><text x="{{.LabelX}}" y="150" fill="#fff" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.LabelX}}" y="140" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.StatusX}}" y="150" fill="#fff" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text><text x="{{.StatusX}}" y="140" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text></g> </svg>`
+
+	// Multi-segment badge templates, every segment has its own color and text after the label
+	curveSegmentsBadge = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient><clipPath id="a"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0h{{.LabelWidth}}v20H0z"/>{{range .Segments}}<path fill="{{.Color}}" d="M{{.X}} 0h{{.Width}}v20H{{.X}}z"/>{{if .Separator}}<path fill="#fff" fill-opacity=".4" d="M{{.X}} 0h1v20H{{.X}}z"/>{{end}}{{end}}<path fill="url(#b)" d="M0 0h{{.Width}}v20H0z"/></g><g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="{{.LabelX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.LabelX}}" y="140" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text>{{range .Segments}}<text x="{{.TextX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="{{.TextLength}}">{{html .Text}}</text><text x="{{.TextX}}" y="140" transform="scale(.1)" textLength="{{.TextLength}}">{{html .Text}}</text>{{end}}</g> </svg>`
+	flatSegmentsBadge  = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><g shape-rendering="crispEdges"><path fill="#555" d="M0 0h{{.LabelWidth}}v20H0z"/>{{range .Segments}}<path fill="{{.Color}}" d="M{{.X}} 0h{{.Width}}v20H{{.X}}z"/>{{if .Separator}}<path fill="#fff" fill-opacity=".4" d="M{{.X}} 0h1v20H{{.X}}z"/>{{end}}{{end}}</g><g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="{{.LabelX}}" y="140" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text>{{range .Segments}}<text x="{{.TextX}}" y="140" transform="scale(.1)" textLength="{{.TextLength}}">{{html .Text}}</text>{{end}}</g> </svg>`
+
+	// badgePadding is the horizontal padding of the label and status texts
+	badgePadding = 5
+	// forTheBadgePadding is the horizontal padding of the texts of the for-the-badge style
+	forTheBadgePadding = 9
+)
+
+var (
+	// badgeColors are the hex colors of the badge color names
+	badgeColors = map[string]string{
+		"red":         "#d6604a",
+		"orange":      "#e5822d",
+		"yellow":      "#d6ae22",
+		"yellowgreen": "#a4a61d",
+		"green":       "#96c40f",
+		"brightgreen": "#4c1",
+		"blue":        "#007ec6",
+		"lightgrey":   "#9a9a9a",
+		"lightgray":   "#9a9a9a",
+		"grey":        "#9a9a9a",
+		"gray":        "#9a9a9a",
+	}
+
+	// hexColorMatch matches the hex colors, with or without #, e.g. `4c1` or `#4c1`
+	hexColorMatch = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+	// defaultThresholds are the coverage percentages at which the badge color changes
+	defaultThresholds = []float64{45, 70}
+	// thresholdPalettes are the colors of the bands between the thresholds, by number of
+	// thresholds, e.g. with 45,70,90 the coverage is red below 45%, yellow below 70%, green
+	// below 90% and bright green above
+	thresholdPalettes = [][]string{
+		{"red", "green"},
+		{"red", "yellow", "green"},
+		{"red", "yellow", "green", "brightgreen"},
+		{"red", "orange", "yellow", "green", "brightgreen"},
+		{"red", "orange", "yellow", "yellowgreen", "green", "brightgreen"},
+	}
 )
 
 var (
 	curveBadgeTmpl *template.Template
 	flatBadgeTmpl  *template.Template
+
+	plasticBadgeTmpl     = template.Must(template.New("").Parse(plasticBadge))
+	forTheBadgeBadgeTmpl = template.Must(template.New("").Parse(forTheBadgeBadge))
+	socialBadgeTmpl      = template.Must(template.New("").Parse(socialBadge))
+
+	curveSegmentsBadgeTmpl = template.Must(template.New("").Parse(curveSegmentsBadge))
+	flatSegmentsBadgeTmpl  = template.Must(template.New("").Parse(flatSegmentsBadge))
 )
 
 func init() {
@@ -31,166 +83,297 @@ func init() {
 }
 
 type badge struct {
-	Status  string
-	StatusX int
-	Label   string
-	Color   string
-	Width   int
+	Status string
+	Label  string
+	Color  string
+	Width  int
+
+	// LabelWidth and StatusWidth are the widths of the label and status parts, in pixels
+	LabelWidth  int
+	StatusWidth int
+	// LabelX and StatusX are the centers of the texts, LabelLength and StatusLength their
+	// lengths, in tenths of a pixel
+	LabelX       int
+	StatusX      int
+	LabelLength  int
+	StatusLength int
+
+	// Logo is the data URI of the logo drawn before the label at LogoX, empty if none
+	Logo      string
+	LogoX     int
+	LogoWidth int
 }
 
-// getBadge gets the badge from img.shields.io and return as []byte
-func getBadgeImgShield(color, style, percent string) string {
-	cacheKey := fmt.Sprintf("%s-%s-%s", color, style, percent)
-	str := ""
-	err := redisCodec.Get(cacheKey, &str)
-	if err == nil {
-		return str
-	}
-	errLogger.Println(err)
+// layout sets the widths and text positions of the badge from the widths of its label and
+// status in the badge font
+func (b *badge) layout() {
+	b.layoutText(textLayout, badgePadding)
+}
 
-	imgURL := fmt.Sprintf("https://img.shields.io/badge/cover.run-%s25-%s.svg?style=%s", percent, color, style)
+// layoutText sets the widths and text positions of the badge with the given text layout
+func (b *badge) layoutText(textLayout func(string, int) (int, int), padding int) {
+	b.LabelWidth, b.LabelLength = textLayout(b.Label, padding)
+	b.StatusWidth, b.StatusLength = textLayout(b.Status, padding)
+	b.Width = b.LabelWidth + b.StatusWidth
+	b.LabelX = b.LabelWidth * 5
+	b.StatusX = (b.LabelWidth*2 + b.StatusWidth) * 5
 
-	resp, err := httpClient.Get(imgURL)
-	if err != nil {
-		errLogger.Println(err)
-		return ""
+	if b.Logo != "" {
+		// the label is moved after the logo
+		shift := b.LogoWidth + logoPadding
+		b.LogoX = padding
+		b.LabelWidth += shift
+		b.Width += shift
+		b.LabelX += shift * 10
+		b.StatusX += shift * 10
 	}
-	defer resp.Body.Close()
-	svg, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
-	if err != nil {
-		errLogger.Println(err)
+}
+
+// badgeColor returns the hex color of a badge color name or of a hex color, e.g. `fe7d37` or
+// `#fe7d37`, grey for unknown colors
+func badgeColor(color string) string {
+	if hex, ok := badgeColors[strings.ToLower(color)]; ok {
+		return hex
 	}
-	err = redisCodec.Set(&cache.Item{
-		Key:        cacheKey,
-		Object:     string(svg),
-		Expiration: -1,
-	})
-	if err != nil {
-		errLogger.Println(err)
+	if hexColorMatch.MatchString(color) {
+		return "#" + strings.ToLower(strings.TrimPrefix(color, "#"))
 	}
-	return string(svg)
+	return badgeColors["grey"]
+}
+
+// validColor returns true if the color is a badge color name or a hex color
+func validColor(color string) bool {
+	_, ok := badgeColors[strings.ToLower(color)]
+	return ok || hexColorMatch.MatchString(color)
 }
 
 // getBadge is a function which will generate SVG rather than fetch from img.shield.io
 func getBadge(color, style, status string) string {
-	const label = "cover.run"
+	return getLabelBadge(defaultLabel, color, style, status, nil)
+}
+
+// bubbleBadge is a badge of the social style, the status is in a bubble next to the label
+type bubbleBadge struct {
+	badge
+
+	// LabelRectWidth is the width of the label box, BubbleX the start of the status bubble
+	// and ArrowX of its arrow
+	LabelRectWidth int
+	BubbleX        float64
+	ArrowX         int
+}
+
+// layout sets the widths and text positions of the social badge, the bubble is 6 pixels
+// after the label
+func (b *bubbleBadge) layout() {
+	b.badge.layout()
+	b.LabelRectWidth = b.LabelWidth - 1
+	b.BubbleX = float64(b.LabelWidth) + 5.5
+	b.ArrowX = b.LabelWidth + 5
+	b.StatusX = (b.LabelWidth*2 + 11 + b.StatusWidth) * 5
+	b.Width = b.LabelWidth + 6 + b.StatusWidth
+}
+
+// getLabelBadge generates the SVG badge with the given label, and the logo before it if not nil
+func getLabelBadge(label, color, style, status string, logo *badgeLogo) string {
 	buf := new(bytes.Buffer)
-	switch strings.ToLower(color) {
-	case "red":
+	b, tmpl := styledBadge(label, color, style, status, logo)
+	tmpl.Execute(buf, b)
+	return buf.String()
+}
+
+// styledBadge returns the badge laid out for the style with its template, the bubble fields
+// are only set for the social style
+func styledBadge(label, color, style, status string, logo *badgeLogo) (*bubbleBadge, *template.Template) {
+	b := &bubbleBadge{
+		badge: badge{
+			Label:  label,
+			Status: status,
+			Color:  badgeColor(color),
+		},
+	}
+	b.setLogo(logo, style)
+
+	switch style {
+	case "plastic":
 		{
-			color = "#d6604a"
+			b.badge.layout()
+			return b, plasticBadgeTmpl
 		}
-	case "green":
+	case "for-the-badge":
 		{
-			color = "#96c40f"
+			b.Label, b.Status = strings.ToUpper(b.Label), strings.ToUpper(b.Status)
+			b.layoutText(forTheBadgeTextLayout, forTheBadgePadding)
+			return b, forTheBadgeBadgeTmpl
 		}
-	case "yellow":
+	case "social":
 		{
-			color = "#d6ae22"
+			// the social style has a capitalized label and no color
+			r, size := utf8.DecodeRuneInString(b.Label)
+			b.Label = string(unicode.ToUpper(r)) + b.Label[size:]
+			b.layout()
+			return b, socialBadgeTmpl
 		}
-	case "yellowgreen":
+	case "flat", "curve", "flat-curve":
 		{
-			color = "#a4a61d"
+			b.badge.layout()
+			return b, curveBadgeTmpl
 		}
+	}
+	b.badge.layout()
+	return b, flatBadgeTmpl
+}
 
-	default:
-		{
-			color = "#9a9a9a"
-		}
+// setLogo sets the logo of the badge, drawn in the style
+func (b *badge) setLogo(logo *badgeLogo, style string) {
+	if logo == nil {
+		return
 	}
+	b.Logo = logo.dataURI(style)
+	b.LogoWidth = logo.Width
+}
 
-	b := &badge{
-		Label:  label,
-		Status: status,
-		Color:  color,
+// curvedStyle returns true if the style has rounded corners, the badges without a template
+// for the style use the curve or flat template
+func curvedStyle(style string) bool {
+	switch style {
+	case "flat", "curve", "flat-curve", "plastic", "social":
+		return true
 	}
+	return false
+}
 
-	switch len(b.Status) {
-	case 1:
-		{
-			b.StatusX = 725
-			b.Width = 78
-		}
-	case 2:
-		{
-			b.StatusX = 745
-			b.Width = 90
-		}
-	case 3:
-		{
-			b.StatusX = 775
-			b.Width = 96
-		}
-	case 4:
-		{
-			b.StatusX = 815
-			b.Width = 104
-		}
-	case 5:
-		{
-			b.StatusX = 835
-			b.Width = 108
-		}
-	case 6:
-		{
-			b.StatusX = 865
-			b.Width = 114
-		}
-	case 7, 8, 9:
-		{
-			b.StatusX = 895
-			b.Width = 120
-		}
+// parseBadgeStyle returns the badge style of the style query string parameter, flat-square
+// for unknown styles
+func parseBadgeStyle(style string) string {
+	switch style = strings.TrimSpace(style); style {
+	case "flat", "plastic", "for-the-badge", "social":
+		return style
+	}
+	return "flat-square"
+}
 
-	default:
-		{
-			// 50units for 7 chars
-			statusWidth := (len(b.Status) * 8)
-			b.StatusX = 685 + (statusWidth + 160)
-			b.Width = 61 + statusWidth + 32
-		}
+// badgeSegment is a segment of a multi-segment badge
+type badgeSegment struct {
+	Text  string
+	Color string
+	// X is the start of the segment, Width its width
+	X     int
+	Width int
+	// TextX is the center of the text, TextLength its length, in tenths of a pixel
+	TextX      int
+	TextLength int
+	// Separator draws a line between the segment and the previous one
+	Separator bool
+}
+
+// segmentsBadge is a badge with the cover.run label followed by several segments
+type segmentsBadge struct {
+	Label    string
+	Width    int
+	Segments []badgeSegment
+
+	// LabelWidth is the width of the label part, in pixels
+	LabelWidth int
+	// LabelX is the center of the label, LabelLength its length, in tenths of a pixel
+	LabelX      int
+	LabelLength int
+}
+
+// getSegmentsBadge generates the SVG badge with the given segments after the cover.run label,
+// only the Text and Color of the segments are needed
+func getSegmentsBadge(style string, segments []badgeSegment) string {
+	buf := new(bytes.Buffer)
+
+	b := &segmentsBadge{Label: defaultLabel}
+	b.LabelWidth, b.LabelLength = textLayout(b.Label, badgePadding)
+	b.LabelX = b.LabelWidth * 5
+	b.Width = b.LabelWidth
+	for i, s := range segments {
+		s.Color = badgeColor(s.Color)
+		s.X = b.Width
+		s.Width, s.TextLength = textLayout(s.Text, badgePadding)
+		s.TextX = (s.X*2 + s.Width) * 5
+		s.Separator = i > 0
+		b.Width += s.Width
+		b.Segments = append(b.Segments, s)
 	}
 
-	switch style {
-	case "flat", "curve", "flat-curve":
-		{
-			curveBadgeTmpl.Execute(buf, b)
-		}
-	default:
-		{
-			flatBadgeTmpl.Execute(buf, b)
-		}
+	if curvedStyle(style) {
+		curveSegmentsBadgeTmpl.Execute(buf, b)
+	} else {
+		flatSegmentsBadgeTmpl.Execute(buf, b)
 	}
 	return buf.String()
 }
 
-// coverageBadge returns the SVG badge after computing the coverage
-func coverageBadge(repo, tag, style string) (string, error) {
-	obj, err := repoCover(repo, tag)
-	if err != nil {
-		if err == ErrQueued {
-			return getBadge("lightgrey", style, "queued"), nil
-		}
+// coverageStatus returns the result of a repository with the color and status of its badge,
+// the color of the coverage is picked with the thresholds, the default ones if nil
+func coverageStatus(repo, tag, ref string, thresholds []float64) (*Object, string, string) {
+	obj, err := repoCover(repo, tag, ref)
+	if err != nil && err != ErrQueued && err != ErrCovInPrgrs {
+		errLogger.Println(err)
+	}
 
-		if err == ErrCovInPrgrs {
-			return getBadge("yellowgreen", style, "testing"), nil
+	switch obj.Status {
+	case StatusQueued:
+		return obj, "lightgrey", "queued"
+	case StatusRunning:
+		return obj, "yellowgreen", "testing"
+	case StatusTimeout:
+		return obj, "orange", "timeout"
+	case StatusPassed:
+		return obj, thresholdColor(obj.Percent, thresholds), obj.Cover
+	case StatusTestsFailed:
+		// the coverage is measured despite failing tests
+		if obj.Output {
+			return obj, thresholdColor(obj.Percent, thresholds), obj.Cover
 		}
-
-		errLogger.Println(err)
 	}
+	return obj, coverageColor(0), "error"
+}
+
+// coverageColor returns the badge color of a coverage percentage with the default thresholds
+func coverageColor(cover float64) string {
+	return thresholdColor(cover, defaultThresholds)
+}
 
-	badgeStatus := obj.Cover
-	cover, err := strconv.ParseFloat(strings.Replace(obj.Cover, "%", "", -1), 64)
-	if err != nil {
-		badgeStatus = "error"
+// thresholdColor returns the badge color of a coverage percentage, the color of the band
+// between the thresholds it falls in. The default thresholds are used if nil.
+func thresholdColor(cover float64, thresholds []float64) string {
+	if thresholds == nil {
+		thresholds = defaultThresholds
+	}
+	band := 0
+	for _, t := range thresholds {
+		if cover >= t {
+			band++
+		}
 	}
+	return thresholdPalettes[len(thresholds)-1][band]
+}
 
-	color := "red"
-	if cover >= 70 {
-		color = "green"
-	} else if cover >= 45 {
-		color = "yellow"
+// failingStatus returns the color and status of the badge of a coverage measured despite
+// failing tests with the number of failing tests, e.g. "72.50%, 2 failing"
+func failingStatus(obj *Object, color, status string) (string, string) {
+	if obj.Status != StatusTestsFailed || !obj.Output {
+		return color, status
 	}
+	return "red", fmt.Sprintf("%s, %d failing", status, failingTests(obj.Failures))
+}
 
-	return getBadge(color, style, badgeStatus), nil
+// coverageBadge returns the SVG badge after computing the coverage. If failing is true, the
+// number of failing tests is added to the coverage.
+func coverageBadge(repo, tag, ref, style string, failing bool, opts *badgeOptions) (string, error) {
+	color, status := coverageBadgeStatus(repo, tag, ref, failing, opts)
+	return getLabelBadge(opts.label(), color, style, status, opts.Logo), nil
+}
+
+// coverageBadgeStatus returns the color and status of the coverage badge with the options
+func coverageBadgeStatus(repo, tag, ref string, failing bool, opts *badgeOptions) (string, string) {
+	obj, color, status := coverageStatus(repo, tag, ref, opts.Thresholds)
+	if failing {
+		color, status = failingStatus(obj, color, status)
+	}
+	return opts.color(color), status
 }
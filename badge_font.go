@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"unicode/utf8"
+)
+
+const (
+	// badgeFontSize is the size of the badge font in pixels
+	badgeFontSize = 11
+	// forTheBadgeFontSize is the size of the font of the for-the-badge style in pixels, and
+	// forTheBadgeLetterSpacing the space added after every letter
+	forTheBadgeFontSize      = 10
+	forTheBadgeLetterSpacing = 1.25
+	// fontUnitsPerEm is the number of font units of the em square of Verdana and DejaVu Sans
+	fontUnitsPerEm = 2048
+	// firstMeasuredRune is the first rune of the width tables, the tables go up to '~'
+	firstMeasuredRune = ' '
+	// unknownRuneWidth is the width, in font units, of the runes not in the tables, e.g. ✓
+	unknownRuneWidth = fontUnitsPerEm
+)
+
+// verdanaWidths are the advance widths of the printable ASCII characters of Verdana, from
+// ' ' to '~', in font units
+var verdanaWidths = []int{
+	720, 806, 940, 1676, 1302, 2204, 1488, 550, 930, 930, 1302, 1676, 745, 930, 745, 930, // ' ' to '/'
+	1302, 1302, 1302, 1302, 1302, 1302, 1302, 1302, 1302, 1302, // '0' to '9'
+	930, 930, 1676, 1676, 1676, 1117, 2048, // ':' to '@'
+	1400, 1404, 1430, 1578, 1295, 1177, 1588, 1539, 862, 931, 1419, 1140, 1726, // 'A' to 'M'
+	1532, 1612, 1235, 1612, 1424, 1400, 1262, 1499, 1400, 2025, 1403, 1260, 1403, // 'N' to 'Z'
+	930, 930, 930, 1676, 1302, 1302, // '[' to '`'
+	1230, 1275, 1067, 1275, 1219, 720, 1275, 1296, 562, 705, 1212, 562, 1992, // 'a' to 'm'
+	1296, 1243, 1275, 1275, 874, 1067, 807, 1296, 1212, 1676, 1212, 1212, 1076, // 'n' to 'z'
+	1300, 930, 1300, 1676, // '{' to '~'
+}
+
+// dejaVuWidths are the advance widths of the printable ASCII characters of DejaVu Sans, from
+// ' ' to '~', in font units
+var dejaVuWidths = []int{
+	651, 821, 942, 1716, 1303, 1946, 1597, 563, 799, 799, 1024, 1716, 651, 739, 651, 690, // ' ' to '/'
+	1303, 1303, 1303, 1303, 1303, 1303, 1303, 1303, 1303, 1303, // '0' to '9'
+	690, 690, 1716, 1716, 1716, 1087, 2048, // ':' to '@'
+	1401, 1405, 1430, 1577, 1294, 1178, 1587, 1540, 604, 604, 1343, 1141, 1767, // 'A' to 'M'
+	1532, 1612, 1235, 1612, 1423, 1300, 1251, 1499, 1401, 2025, 1403, 1251, 1403, // 'N' to 'Z'
+	799, 690, 799, 1716, 1024, 1024, // '[' to '`'
+	1255, 1300, 1126, 1300, 1260, 721, 1300, 1298, 569, 569, 1186, 569, 1995, // 'a' to 'm'
+	1298, 1253, 1300, 1300, 842, 1067, 803, 1298, 1212, 1675, 1212, 1212, 1075, // 'n' to 'z'
+	1303, 690, 1303, 1716, // '{' to '~'
+}
+
+// runeWidth returns the width of the rune in the table, in font units
+func runeWidth(widths []int, r rune) int {
+	i := int(r - firstMeasuredRune)
+	if i < 0 || i >= len(widths) {
+		return unknownRuneWidth
+	}
+	return widths[i]
+}
+
+// textWidth returns the width of the text in the badge font, in pixels. The badges are
+// rendered with DejaVu Sans or Verdana, whichever is installed, so the text is measured with
+// both and the widest is used.
+func textWidth(text string) float64 {
+	verdana, dejaVu := 0, 0
+	for _, r := range text {
+		verdana += runeWidth(verdanaWidths, r)
+		dejaVu += runeWidth(dejaVuWidths, r)
+	}
+	units := verdana
+	if dejaVu > units {
+		units = dejaVu
+	}
+	return float64(units) * badgeFontSize / fontUnitsPerEm
+}
+
+// textLayout returns the width in pixels of a badge part with the text, padded on both
+// sides, and the length of the text in tenths of a pixel, for the textLength attribute
+func textLayout(text string, padding int) (int, int) {
+	w := textWidth(text)
+	return int(math.Ceil(w)) + 2*padding, int(math.Round(w * 10))
+}
+
+// forTheBadgeTextLayout is textLayout for the for-the-badge style, whose texts are in a
+// smaller font with letter spacing
+func forTheBadgeTextLayout(text string, padding int) (int, int) {
+	w := textWidth(text)*forTheBadgeFontSize/badgeFontSize + forTheBadgeLetterSpacing*float64(utf8.RuneCountInString(text))
+	return int(math.Ceil(w)) + 2*padding, int(math.Round(w * 10))
+}
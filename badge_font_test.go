@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestBadgeLayout(t *testing.T) {
+	for name, widths := range map[string][]int{"verdana": verdanaWidths, "dejavu": dejaVuWidths} {
+		if len(widths) != '~'-' '+1 {
+			t.Log("Expected a width for every printable ASCII character in", name, "got", len(widths))
+			t.Fail()
+		}
+	}
+
+	if w := textWidth("i"); w >= textWidth("W") || w <= 0 {
+		t.Log("Expected i to be narrower than W, got", w, textWidth("W"))
+		t.Fail()
+	}
+	if w := textWidth("✓"); w != badgeFontSize {
+		t.Log("Expected an unknown rune to be one em wide, got", w)
+		t.Fail()
+	}
+
+	for _, tc := range [][2]string{{"cover.run", "100%"}, {"a much longer custom label", "87.50%, 2 failing"}, {"go", "1"}} {
+		b := &badge{Label: tc[0], Status: tc[1]}
+		b.layout()
+		labelWidth, statusWidth := textWidth(tc[0]), textWidth(tc[1])
+		if float64(b.LabelWidth) < labelWidth+2*badgePadding || float64(b.StatusWidth) < statusWidth+2*badgePadding {
+			t.Log(tc, "expected the texts to fit, got", b.LabelWidth, b.StatusWidth)
+			t.Fail()
+		}
+		if b.Width != b.LabelWidth+b.StatusWidth || b.LabelX*2 != b.LabelWidth*10 || b.StatusX != b.LabelWidth*10+b.StatusWidth*5 {
+			t.Log(tc, "unexpected layout", b)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// pngMaxScale is the maximum scale of the PNG badges, e.g. 2 for retina displays
+const pngMaxScale = 4
+
+var (
+	// ErrInvalidScale is the error returned when the scale of a PNG badge is not a number
+	// between 1 and pngMaxScale
+	ErrInvalidScale = errors.New("Invalid scale")
+
+	// goRegular and goBold are the fonts of the PNG badges, the Go fonts are embedded so no
+	// font needs to be installed
+	goRegular = mustParseFont(goregular.TTF)
+	goBold    = mustParseFont(gobold.TTF)
+
+	// Gradients drawn over the badges, as the gradients of the SVG templates
+	curveGradient = []gradientStop{
+		{0, color.NRGBA{0xbb, 0xbb, 0xbb, 0x1a}},
+		{1, color.NRGBA{0, 0, 0, 0x1a}},
+	}
+	plasticGradient = []gradientStop{
+		{0, color.NRGBA{0xff, 0xff, 0xff, 0xb3}},
+		{.1, color.NRGBA{0xaa, 0xaa, 0xaa, 0x1a}},
+		{.9, color.NRGBA{0, 0, 0, 0x4d}},
+		{1, color.NRGBA{0, 0, 0, 0x80}},
+	}
+	socialGradient = []gradientStop{
+		{0, color.NRGBA{0xfc, 0xfc, 0xfc, 0}},
+		{1, color.NRGBA{0, 0, 0, 0x1a}},
+	}
+
+	// textShadowColor is the color of the shadow of the texts, as in the SVG templates
+	textShadowColor = color.NRGBA{0x01, 0x01, 0x01, 0x4d}
+)
+
+// mustParseFont parses the TrueType font, it panics on error
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// parseBadgeScale returns the scale of the `scale` query string parameter, 1 if not set
+func parseBadgeScale(scale string) (int, error) {
+	scale = strings.TrimSpace(scale)
+	if scale == "" {
+		return 1, nil
+	}
+	s, err := strconv.Atoi(scale)
+	if err != nil || s < 1 || s > pngMaxScale {
+		return 0, ErrInvalidScale
+	}
+	return s, nil
+}
+
+// parseHexColor returns the color of a hex color, e.g. `#4c1` or `#96c40f`
+func parseHexColor(hex string) color.NRGBA {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, _ := strconv.ParseUint(hex, 16, 32)
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+}
+
+// gradientStop is the color of a vertical gradient at the offset, from 0 at the top to 1 at
+// the bottom
+type gradientStop struct {
+	offset float64
+	color  color.NRGBA
+}
+
+// verticalGradient is an image of a vertical gradient over the height, in pixels
+type verticalGradient struct {
+	stops  []gradientStop
+	height float64
+}
+
+func (g *verticalGradient) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+func (g *verticalGradient) Bounds() image.Rectangle {
+	return image.Rect(-1e9, -1e9, 1e9, 1e9)
+}
+
+func (g *verticalGradient) At(x, y int) color.Color {
+	t := (float64(y) + .5) / g.height
+	if t <= g.stops[0].offset {
+		return g.stops[0].color
+	}
+	for i := 1; i < len(g.stops); i++ {
+		a, b := g.stops[i-1], g.stops[i]
+		if t <= b.offset {
+			f := (t - a.offset) / (b.offset - a.offset)
+			mix := func(a, b uint8) uint8 {
+				return uint8(float64(a) + (float64(b)-float64(a))*f + .5)
+			}
+			return color.NRGBA{mix(a.color.R, b.color.R), mix(a.color.G, b.color.G), mix(a.color.B, b.color.B), mix(a.color.A, b.color.A)}
+		}
+	}
+	return g.stops[len(g.stops)-1].color
+}
+
+// pngCanvas draws the shapes and texts of a badge at a scale, the coordinates are the ones
+// of the SVG badge
+type pngCanvas struct {
+	img   *image.RGBA
+	scale float32
+}
+
+func newPNGCanvas(width, height, scale int) *pngCanvas {
+	return &pngCanvas{
+		img:   image.NewRGBA(image.Rect(0, 0, width*scale, height*scale)),
+		scale: float32(scale),
+	}
+}
+
+// gradient returns the vertical gradient over the height of the canvas
+func (c *pngCanvas) gradient(stops []gradientStop) image.Image {
+	return &verticalGradient{stops: stops, height: float64(c.img.Bounds().Dy())}
+}
+
+// rect fills the rectangle, the corners on the left are rounded with the radius rl and the
+// ones on the right with rr
+func (c *pngCanvas) rect(x0, y0, x1, y1, rl, rr float32, src image.Image) {
+	s := c.scale
+	x0, y0, x1, y1, rl, rr = x0*s, y0*s, x1*s, y1*s, rl*s, rr*s
+	z := vector.NewRasterizer(c.img.Bounds().Dx(), c.img.Bounds().Dy())
+	z.MoveTo(x0+rl, y0)
+	z.LineTo(x1-rr, y0)
+	z.QuadTo(x1, y0, x1, y0+rr)
+	z.LineTo(x1, y1-rr)
+	z.QuadTo(x1, y1, x1-rr, y1)
+	z.LineTo(x0+rl, y1)
+	z.QuadTo(x0, y1, x0, y1-rl)
+	z.LineTo(x0, y0+rl)
+	z.QuadTo(x0, y0, x0+rl, y0)
+	z.ClosePath()
+	z.Draw(c.img, c.img.Bounds(), src, image.Point{})
+}
+
+// polygon fills the polygon of the points, given as x, y pairs
+func (c *pngCanvas) polygon(src image.Image, points ...float32) {
+	s := c.scale
+	z := vector.NewRasterizer(c.img.Bounds().Dx(), c.img.Bounds().Dy())
+	z.MoveTo(points[0]*s, points[1]*s)
+	for i := 2; i+1 < len(points); i += 2 {
+		z.LineTo(points[i]*s, points[i+1]*s)
+	}
+	z.ClosePath()
+	z.Draw(c.img, c.img.Bounds(), src, image.Point{})
+}
+
+// text draws the text centered on x with its baseline on y. The font is shrunk if the text
+// is longer than length, as the textLength of the SVG badges.
+func (c *pngCanvas) text(text string, x, y, length, size, spacing float64, bold bool, col color.Color) {
+	f := goRegular
+	if bold {
+		f = goBold
+	}
+	s := float64(c.scale)
+	spacing *= s
+	width := func(face font.Face) float64 {
+		return float64(font.MeasureString(face, text))/64 + spacing*float64(utf8.RuneCountInString(text))
+	}
+
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size * s, DPI: 72})
+	if err != nil {
+		errLogger.Println(err)
+		return
+	}
+	w := width(face)
+	if max := length * s; w > max && max > 0 {
+		face.Close()
+		face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: size * s * max / w, DPI: 72})
+		if err != nil {
+			errLogger.Println(err)
+			return
+		}
+		w = width(face)
+	}
+	defer face.Close()
+
+	d := &font.Drawer{
+		Dst:  c.img,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.Point26_6{X: fixed.Int26_6((x*s - w/2) * 64), Y: fixed.Int26_6(y * s * 64)},
+	}
+	for _, r := range text {
+		d.DrawString(string(r))
+		d.Dot.X += fixed.Int26_6(spacing * 64)
+	}
+}
+
+// getLabelBadgePNG generates the PNG badge with the given label, and the logo before it if not
+// nil, laid out as the SVG badge of the style and drawn at the scale
+func getLabelBadgePNG(label, color, style, status string, logo *badgeLogo, scale int) ([]byte, error) {
+	b, _ := styledBadge(label, color, style, status, logo)
+
+	height, logoY := 20, float32(3)
+	switch style {
+	case "plastic":
+		height, logoY = 18, 2
+	case "for-the-badge":
+		height, logoY = 28, 7
+	}
+	c := newPNGCanvas(b.Width, height, scale)
+	// drawLogo draws the logo over the background and under the texts, as in the SVG badges
+	drawLogo := func() {
+		if logo != nil {
+			c.logo(logo, style, float32(b.LogoX), logoY, float32(b.LogoWidth), logoHeight)
+		}
+	}
+
+	labelColor := image.NewUniform(parseHexColor("#555"))
+	statusColor := image.NewUniform(parseHexColor(b.Color))
+	white := parseHexColor("#fff")
+	lw, sw, w, h := float32(b.LabelWidth), float32(b.StatusWidth), float32(b.Width), float32(height)
+	lx, sx := float64(b.LabelX)/10, float64(b.StatusX)/10
+	ll, sl := float64(b.LabelLength)/10, float64(b.StatusLength)/10
+
+	switch {
+	case style == "social":
+		border := image.NewUniform(parseHexColor("#d5d5d5"))
+		bubble := image.NewUniform(parseHexColor("#fafafa"))
+		text := parseHexColor("#333")
+		c.rect(0, 0, lw, h, 2, 2, border)
+		c.rect(1, 1, lw-1, h-1, 1.5, 1.5, image.NewUniform(parseHexColor("#fcfcfc")))
+		c.rect(1, 1, lw-1, h-1, 1.5, 1.5, c.gradient(socialGradient))
+		bx := float32(b.BubbleX)
+		c.rect(bx-.5, 0, bx+sw+.5, h, 2, 2, border)
+		c.rect(bx+.5, 1, bx+sw-.5, h-1, 1.5, 1.5, bubble)
+		c.polygon(border, bx, 6, bx-3.7, 9.5, bx-3.7, 10.5, bx, 14)
+		c.polygon(bubble, bx+.6, 7, bx-2.6, 9.9, bx-2.6, 10.1, bx+.6, 13)
+		drawLogo()
+		for _, t := range []struct {
+			text      string
+			x, length float64
+		}{{b.Label, lx, ll}, {b.Status, sx, sl}} {
+			c.text(t.text, t.x, 15, t.length, badgeFontSize, 0, true, white)
+			c.text(t.text, t.x, 14, t.length, badgeFontSize, 0, true, text)
+		}
+	case style == "for-the-badge":
+		c.rect(0, 0, lw, h, 0, 0, labelColor)
+		c.rect(lw, 0, w, h, 0, 0, statusColor)
+		drawLogo()
+		c.text(b.Label, lx, 17.5, ll, forTheBadgeFontSize, forTheBadgeLetterSpacing, false, white)
+		c.text(b.Status, sx, 17.5, sl, forTheBadgeFontSize, forTheBadgeLetterSpacing, true, white)
+	case curvedStyle(style):
+		radius, gradient, textY := float32(3), curveGradient, 14.0
+		if style == "plastic" {
+			radius, gradient, textY = 4, plasticGradient, 13
+		}
+		c.rect(0, 0, lw, h, radius, 0, labelColor)
+		c.rect(lw, 0, w, h, 0, radius, statusColor)
+		c.rect(0, 0, w, h, radius, radius, c.gradient(gradient))
+		drawLogo()
+		c.text(b.Label, lx, textY+1, ll, badgeFontSize, 0, false, textShadowColor)
+		c.text(b.Label, lx, textY, ll, badgeFontSize, 0, false, white)
+		c.text(b.Status, sx, textY+1, sl, badgeFontSize, 0, false, textShadowColor)
+		c.text(b.Status, sx, textY, sl, badgeFontSize, 0, false, white)
+	default:
+		c.rect(0, 0, lw, h, 0, 0, labelColor)
+		c.rect(lw, 0, w, h, 0, 0, statusColor)
+		drawLogo()
+		c.text(b.Label, lx, 14, ll, badgeFontSize, 0, false, white)
+		c.text(b.Status, sx, 14, sl, badgeFontSize, 0, false, white)
+	}
+
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, c.img)
+	return buf.Bytes(), err
+}
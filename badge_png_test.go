@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseBadgeScale(t *testing.T) {
+	for scale, expected := range map[string]int{"": 1, "1": 1, " 2 ": 2, "4": 4} {
+		if s, err := parseBadgeScale(scale); err != nil || s != expected {
+			t.Log(scale, "expected", expected, "got", s, err)
+			t.Fail()
+		}
+	}
+	for _, scale := range []string{"0", "5", "1.5", "x"} {
+		if _, err := parseBadgeScale(scale); err != ErrInvalidScale {
+			t.Log(scale, "expected", ErrInvalidScale, "got", err)
+			t.Fail()
+		}
+	}
+}
+
+func TestGetLabelBadgePNG(t *testing.T) {
+	for style, height := range map[string]int{"flat": 20, "flat-square": 20, "plastic": 18, "for-the-badge": 28, "social": 20} {
+		data, err := getLabelBadgePNG("cover.run", "red", style, "100%", nil, 2)
+		if err != nil {
+			t.Log(style, err)
+			t.Fail()
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Log(style, err)
+			t.Fail()
+			continue
+		}
+
+		b, _ := styledBadge("cover.run", "red", style, "100%", nil)
+		if size := img.Bounds().Size(); size.X != b.Width*2 || size.Y != height*2 {
+			t.Log(style, "expected the SVG size at scale 2, got", size)
+			t.Fail()
+		}
+		if style == "social" {
+			continue
+		}
+		// the middle of the right edge of the label and of the left edge of the status
+		label, status := img.At(b.LabelWidth*2-2, height), img.At(b.LabelWidth*2+1, height)
+		if r, g, _, _ := label.RGBA(); r>>8 > 0x60 || g>>8 > 0x60 {
+			t.Log(style, "expected a dark grey label, got", label)
+			t.Fail()
+		}
+		if r, g, _, _ := status.RGBA(); r>>8 < 0xa0 || g>>8 > 0x70 {
+			t.Log(style, "expected a red status, got", status)
+			t.Fail()
+		}
+	}
+}
+
+func TestGetLabelBadgePNGLogo(t *testing.T) {
+	logo := &badgeLogo{Name: "gitlab", Color: "#fc6d26", Width: logoWidth}
+	for style, y := range map[string]int{"flat": 3, "plastic": 2, "for-the-badge": 7, "social": 3} {
+		data, err := getLabelBadgePNG("cover.run", "red", style, "100%", logo, 2)
+		if err != nil {
+			t.Log(style, err)
+			t.Fail()
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Log(style, err)
+			t.Fail()
+			continue
+		}
+
+		b, _ := styledBadge("cover.run", "red", style, "100%", logo)
+		if img.Bounds().Dx() != b.Width*2 {
+			t.Log(style, "expected the width of the SVG badge with the logo", b.Width*2, "got", img.Bounds().Dx())
+			t.Fail()
+		}
+		// the middle of the logo is filled with its color
+		x, y := (b.LogoX+b.LogoWidth/2)*2, (y+logoHeight/2)*2
+		if r, g, bl, _ := img.At(x, y).RGBA(); r>>8 != 0xfc || g>>8 != 0x6d || bl>>8 != 0x26 {
+			t.Log(style, "expected a #fc6d26 logo, got", r>>8, g>>8, bl>>8)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerBadgePNG(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/badge.png", HandlerBadgePNG)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/badge.png?value=75%25&color=yellow&scale=2", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Log("Expected a PNG badge, got", rec.Code, rec.Header())
+		t.FailNow()
+	}
+	if _, err := png.Decode(rec.Body); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	// the logos are drawn as in the SVG badges
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/badge.png?value=75%25&logo=go", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Log("Expected a PNG badge with a logo, got", rec.Code, rec.Header())
+		t.Fail()
+	}
+
+	for _, query := range []string{"value=75%25&scale=10", "value=75%25&logo=nope"} {
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/badge.png?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Log(query, "expected", http.StatusBadRequest, "got", rec.Code)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerRepoPNG(t *testing.T) {
+	oldStore := store
+	defer func() {
+		store = oldStore
+	}()
+	store = newMemoryStore()
+	saveCover(&Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Cover: "92.00%", Output: true, Status: StatusPassed, Percent: 92}, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/go/{repo:.*}.png", HandlerRepoPNG)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo.png?tag=1.10&color=4c1&scale=2", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Log("Expected a PNG badge, got", rec.Code, rec.Header())
+		t.FailNow()
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// the status is drawn in the given color, on the right
+	bounds := img.Bounds()
+	if red, green, blue, _ := img.At(bounds.Max.X-2, bounds.Max.Y/2).RGBA(); red>>8 != 0x44 || green>>8 != 0xcc || blue>>8 != 0x11 {
+		t.Log("Expected a #4c1 status, got", red>>8, green>>8, blue>>8)
+		t.Fail()
+	}
+
+	for _, query := range []string{"scale=0", "logo=nope", "color=nope"} {
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo.png?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Log(query, "expected", http.StatusBadRequest, "got", rec.Code)
+			t.Fail()
+		}
+	}
+}
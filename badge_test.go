@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetBadge(t *testing.T) {
+	expected := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="106" height="20"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient><clipPath id="a"><rect width="106" height="20" rx="3" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0h63v20H0z"/><path fill="#d6604a" d="M63 0h43v20H63z"/><path fill="url(#b)" d="M0 0h106v20H0z"/></g><g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="315" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="528">cover.run</text><text x="315" y="140" transform="scale(.1)" textLength="528">cover.run</text><text x="845" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="328">100%</text><text x="845" y="140" transform="scale(.1)" textLength="328">100%</text></g> </svg>`
+	str := getBadge("red", "flat", "100%")
+	if str != expected {
+		t.Log("Expected svg badge, got", str)
+		t.Fail()
+	}
+}
+
+func TestBadgeStyles(t *testing.T) {
+	for style, expected := range map[string]string{"flat": "flat", " plastic ": "plastic", "for-the-badge": "for-the-badge", "social": "social", "": "flat-square", "3d": "flat-square"} {
+		if got := parseBadgeStyle(style); got != expected {
+			t.Log(style, "expected", expected, "got", got)
+			t.Fail()
+		}
+	}
+
+	plastic := getBadge("green", "plastic", "87.50%")
+	if !strings.Contains(plastic, `height="18"`) || !strings.Contains(plastic, `rx="4"`) || !strings.Contains(plastic, `fill="#96c40f"`) {
+		t.Log("Expected a plastic badge, got", plastic)
+		t.Fail()
+	}
+
+	forTheBadge := getBadge("green", "for-the-badge", "87.50%")
+	if !strings.Contains(forTheBadge, `height="28"`) || !strings.Contains(forTheBadge, ">COVER.RUN<") || !strings.Contains(forTheBadge, `font-weight="bold"`) {
+		t.Log("Expected an uppercase for-the-badge badge, got", forTheBadge)
+		t.Fail()
+	}
+	if w, _ := forTheBadgeTextLayout("COVER.RUN", forTheBadgePadding); w <= 63 {
+		t.Log("Expected the for-the-badge label to be wider than the flat one, got", w)
+		t.Fail()
+	}
+
+	social := getBadge("green", "social", "87.50%")
+	if !strings.Contains(social, ">Cover.run<") || strings.Contains(social, "#96c40f") || !strings.Contains(social, `fill="#fafafa"`) {
+		t.Log("Expected a social badge with a capitalized label and no color, got", social)
+		t.Fail()
+	}
+}
+
+func TestFailingStatus(t *testing.T) {
+	failures := []FailedPackage{{Name: "github.com/user/repo", Tests: []string{"TestA", "TestB"}}}
+	tt := []struct {
+		obj           *Object
+		color, status string
+	}{
+		{&Object{Cover: "72.50%", Output: true, Status: StatusTestsFailed, Failures: failures}, "red", "72.50%, 2 failing"},
+		{&Object{Cover: "72.50%", Output: true, Status: StatusPassed}, "green", "72.50%"},
+		{&Object{Cover: ErrTestsFailed.Error(), Status: StatusTestsFailed, Failures: failures}, "green", "72.50%"},
+	}
+	for _, tc := range tt {
+		if color, status := failingStatus(tc.obj, "green", "72.50%"); color != tc.color || status != tc.status {
+			t.Log(tc.obj.Status, tc.obj.Output, "expected", tc.color, tc.status, "got", color, status)
+			t.Fail()
+		}
+	}
+}
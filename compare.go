@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Status of a comparison
+	compareDone    = "done"
+	compareQueued  = "queued"
+	compareTesting = "testing"
+	compareError   = "error"
+)
+
+// ErrNoHead is the error returned when the head ref of a comparison is not given
+var ErrNoHead = errors.New("The head ref to compare is required")
+
+// CoverageDelta is the coverage of a package or file in the base and head refs. A package
+// or file missing in a ref has no statements in it.
+type CoverageDelta struct {
+	Name           string
+	BaseStatements int
+	BaseCovered    int
+	BasePercent    float64
+	HeadStatements int
+	HeadCovered    int
+	HeadPercent    float64
+	// Delta is the difference of the head and base coverage, in percentage points
+	Delta float64
+}
+
+// UncoveredLine is a line which is not covered in the head ref, but was covered or did not
+// exist in the base ref
+type UncoveredLine struct {
+	File string
+	Line int
+	Text string
+}
+
+// CompareRef is a side of a comparison
+type CompareRef struct {
+	Ref    string
+	Commit string
+	Cover  string
+	// Percent is the statement weighted coverage of all packages
+	Percent float64
+}
+
+// CompareResponse is the JSON response with the coverage difference of two refs
+type CompareResponse struct {
+	Repo string
+	Tag  string
+	// Status is "done" once both refs are measured, "queued" or "testing" until then and
+	// "error" if either could not be measured
+	Status string
+	Base   CompareRef
+	Head   CompareRef
+	// Delta is the difference of the total head and base coverage, in percentage points
+	Delta    float64
+	Packages []CoverageDelta
+	Files    []CoverageDelta
+	// Uncovered are the lines newly uncovered in the head ref, they are only known if the
+	// coverage reports of both refs are available
+	Uncovered []UncoveredLine
+}
+
+// delta returns the difference of two percentages, rounded as the percentages
+func delta(head, base float64) float64 {
+	return math.Round((head-base)*100) / 100
+}
+
+// coverageDeltas returns the deltas of the packages or files of both refs, sorted by name
+func coverageDeltas(base, head map[string]FileCoverage) []CoverageDelta {
+	names := make([]string, 0, len(base)+len(head))
+	for name := range base {
+		names = append(names, name)
+	}
+	for name := range head {
+		if _, ok := base[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	deltas := make([]CoverageDelta, 0, len(names))
+	for _, name := range names {
+		b, h := base[name], head[name]
+		deltas = append(deltas, CoverageDelta{
+			Name:           name,
+			BaseStatements: b.Statements,
+			BaseCovered:    b.Covered,
+			BasePercent:    b.Percent,
+			HeadStatements: h.Statements,
+			HeadCovered:    h.Covered,
+			HeadPercent:    h.Percent,
+			Delta:          delta(h.Percent, b.Percent),
+		})
+	}
+	return deltas
+}
+
+// packagesByName returns the packages by name, as FileCoverage which has the same fields
+func packagesByName(pkgs []PackageCoverage) map[string]FileCoverage {
+	m := make(map[string]FileCoverage, len(pkgs))
+	for _, pkg := range pkgs {
+		m[pkg.Name] = FileCoverage(pkg)
+	}
+	return m
+}
+
+// filesByName returns the files by name
+func filesByName(files []FileCoverage) map[string]FileCoverage {
+	m := make(map[string]FileCoverage, len(files))
+	for _, f := range files {
+		m[f.Name] = f
+	}
+	return m
+}
+
+// uncoveredLines returns the uncovered lines of a profile by their trimmed text, with the
+// number of times each appears
+func uncoveredLines(p *Profile, source string) map[string]int {
+	lines := make(map[string]int)
+	states := lineStates(p)
+	for n, text := range strings.Split(source, "\n") {
+		if states[n+1] == lineUncovered {
+			lines[strings.TrimSpace(text)]++
+		}
+	}
+	return lines
+}
+
+// newlyUncovered returns the lines uncovered in the head report which were not uncovered in
+// the base report. Lines move between refs, so they are matched by their text in the same
+// file: a head line is newly uncovered if the base file does not have as many uncovered
+// lines with the same text.
+func newlyUncovered(base, head *Report) []UncoveredLine {
+	baseProfiles := make(map[string]*Profile, len(base.Profiles))
+	for _, p := range base.Profiles {
+		baseProfiles[p.FileName] = p
+	}
+
+	lines := make([]UncoveredLine, 0)
+	for _, p := range head.Profiles {
+		baseLines := make(map[string]int)
+		if bp, ok := baseProfiles[p.FileName]; ok {
+			baseLines = uncoveredLines(bp, base.Sources[bp.FileName])
+		}
+
+		states := lineStates(p)
+		for n, text := range strings.Split(head.Sources[p.FileName], "\n") {
+			if states[n+1] != lineUncovered {
+				continue
+			}
+			trimmed := strings.TrimSpace(text)
+			if baseLines[trimmed] > 0 {
+				baseLines[trimmed]--
+				continue
+			}
+			lines = append(lines, UncoveredLine{File: p.FileName, Line: n + 1, Text: text})
+		}
+	}
+	return lines
+}
+
+// compareStatus returns the status of a comparison side from its result
+func compareStatus(obj *Object) string {
+	switch obj.Status {
+	case StatusQueued:
+		return compareQueued
+	case StatusRunning:
+		return compareTesting
+	case StatusPassed:
+		return compareDone
+	}
+	// the coverage is measured despite failing tests
+	if obj.Output {
+		return compareDone
+	}
+	return compareError
+}
+
+// compareRefs returns the coverage difference of the base and head refs of a repository,
+// the refs are queued if they are not measured yet
+func compareRefs(repo, tag, base, head string) *CompareResponse {
+	baseObj, _ := repoCover(repo, tag, base)
+	headObj, _ := repoCover(repo, tag, head)
+
+	resp := &CompareResponse{
+		Repo:   repo,
+		Tag:    headObj.Tag,
+		Base:   CompareRef{Ref: base, Commit: baseObj.Commit, Cover: baseObj.Cover},
+		Head:   CompareRef{Ref: head, Commit: headObj.Commit, Cover: headObj.Cover},
+		Status: compareDone,
+	}
+
+	// an error of either ref is reported first, there is nothing to wait for then
+	for _, status := range []string{compareError, compareTesting, compareQueued} {
+		if compareStatus(baseObj) == status || compareStatus(headObj) == status {
+			resp.Status = status
+			break
+		}
+	}
+	if resp.Status != compareDone {
+		return resp
+	}
+
+	resp.Base.Percent = baseObj.Percent
+	resp.Head.Percent = headObj.Percent
+	resp.Delta = delta(resp.Head.Percent, resp.Base.Percent)
+	resp.Packages = coverageDeltas(packagesByName(baseObj.Packages), packagesByName(headObj.Packages))
+	resp.Files = coverageDeltas(filesByName(baseObj.Files), filesByName(headObj.Files))
+
+	// the lines are only compared if both reports are the ones of the compared results
+	baseReport, err := repoReport(repo, baseObj.Tag, base)
+	if err != nil || !baseReport.matches(baseObj) {
+		return resp
+	}
+	headReport, err := repoReport(repo, headObj.Tag, head)
+	if err != nil || !headReport.matches(headObj) {
+		return resp
+	}
+	resp.Uncovered = newlyUncovered(baseReport, headReport)
+
+	return resp
+}
+
+// compareParams returns the repo, tag, base and head of a comparison request
+func compareParams(r *http.Request) (repo, tag, base, head string, err error) {
+	query := r.URL.Query()
+	tag = strings.TrimSpace(query.Get("tag"))
+	if tag == "" {
+		tag = DefaultTag
+	}
+	repo = strings.TrimSpace(mux.Vars(r)["repo"])
+
+	base = strings.TrimSpace(query.Get("base"))
+	head = strings.TrimSpace(query.Get("head"))
+	if head == "" {
+		err = ErrNoHead
+		return
+	}
+	if !validRef(base) || !validRef(head) {
+		err = ErrInvalidRef
+	}
+	return
+}
+
+// HandlerRepoCompare returns the coverage difference of the `base` and `head` refs of a
+// repository as JSON. The base is the default branch if it is not given.
+func HandlerRepoCompare(w http.ResponseWriter, r *http.Request) {
+	repo, tag, base, head, err := compareParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(compareRefs(repo, tag, base, head))
+}
+
+// compareBadge returns the SVG badge with the coverage delta of the comparison
+func compareBadge(resp *CompareResponse, style string) string {
+	switch resp.Status {
+	case compareQueued:
+		return getBadge("lightgrey", style, "queued")
+	case compareTesting:
+		return getBadge("yellowgreen", style, "testing")
+	case compareError:
+		return getBadge("lightgrey", style, "error")
+	}
+
+	color := "green"
+	if resp.Delta < 0 {
+		color = "red"
+	}
+	return getBadge(color, style, fmt.Sprintf("%+.1f%%", resp.Delta))
+}
+
+// HandlerRepoCompareSVG returns the SVG badge with the coverage delta of the `base` and
+// `head` refs of a repository, e.g. +1.3%
+func HandlerRepoCompareSVG(w http.ResponseWriter, r *http.Request) {
+	badgeStyle := parseBadgeStyle(r.URL.Query().Get("style"))
+
+	var svg string
+	repo, tag, base, head, err := compareParams(r)
+	if err != nil {
+		svg = getBadge("lightgrey", badgeStyle, "error")
+	} else {
+		svg = compareBadge(compareRefs(repo, tag, base, head), badgeStyle)
+	}
+
+	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
+	w.Header().Set("pragma", "no-cache")
+	w.Header().Set("expires", "-1")
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	w.Write([]byte(svg))
+}
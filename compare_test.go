@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testCompareReports returns the reports of a base ref and a head ref which moves the lines
+// of a.go down and stops covering two of them
+func testCompareReports(t *testing.T) (*Report, *Report) {
+	base, err := parseProfiles(strings.NewReader(`mode: set
+github.com/user/repo/a.go:3.14,4.10 1 1
+github.com/user/repo/a.go:4.10,6.3 1 0
+github.com/user/repo/a.go:7.2,7.10 1 1
+`))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	head, err := parseProfiles(strings.NewReader(`mode: set
+github.com/user/repo/a.go:4.14,5.10 1 1
+github.com/user/repo/a.go:5.10,7.3 1 0
+github.com/user/repo/a.go:8.2,9.12 2 0
+`))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	return &Report{
+		Profiles: base,
+		Sources: map[string]string{
+			"github.com/user/repo/a.go": "package repo\n\nfunc a(b bool) {\n\tif b {\n\t\treturn\n\t}\n\ta()\n}\n",
+		},
+	}, &Report{
+		Profiles: head,
+		Sources: map[string]string{
+			"github.com/user/repo/a.go": "package repo\n\n// a does a\nfunc a(b bool) {\n\tif b {\n\t\treturn\n\t}\n\ta()\n\tb = false\n}\n",
+		},
+	}
+}
+
+func TestNewlyUncovered(t *testing.T) {
+	base, head := testCompareReports(t)
+
+	lines := newlyUncovered(base, head)
+	if len(lines) != 2 || lines[0].Line != 8 || lines[0].Text != "\ta()" || lines[1].Line != 9 {
+		t.Log("Expected lines 8 and 9 to be newly uncovered, got", lines)
+		t.Fail()
+	}
+
+	lines = newlyUncovered(&Report{}, head)
+	if len(lines) != 4 {
+		t.Log("Expected all the uncovered lines of a new file, got", lines)
+		t.Fail()
+	}
+}
+
+func TestHandlerRepoCompare(t *testing.T) {
+	oldStore := store
+	defer func() { store = oldStore }()
+	store = newMemoryStore()
+
+	baseReport, headReport := testCompareReports(t)
+	for _, ref := range []string{"", "feature"} {
+		report := baseReport
+		if ref != "" {
+			report = headReport
+		}
+		obj := &Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Ref: ref, Output: true}
+		obj.Cover, obj.Packages = computeCoverage(report.Profiles)
+		obj.Files = fileCoverage(report.Profiles)
+		saveCover(obj, report)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/go/{repo:.*}/compare.json", HandlerRepoCompare)
+	r.HandleFunc("/go/{repo:.*}/compare.svg", HandlerRepoCompareSVG)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/compare.json?tag=1.10&head=feature", nil))
+	resp := &CompareResponse{}
+	err := json.NewDecoder(rec.Body).Decode(resp)
+	if err != nil || resp.Status != compareDone {
+		t.Log("Unexpected response", resp, err)
+		t.FailNow()
+	}
+
+	if resp.Base.Percent != 66.67 || resp.Head.Percent != 25 || resp.Delta != -41.67 {
+		t.Log("Unexpected delta", resp.Base, resp.Head, resp.Delta)
+		t.Fail()
+	}
+
+	if len(resp.Packages) != 1 || resp.Packages[0].Delta != -41.67 || len(resp.Files) != 1 || resp.Files[0].HeadStatements != 4 {
+		t.Log("Unexpected package and file deltas", resp.Packages, resp.Files)
+		t.Fail()
+	}
+
+	if len(resp.Uncovered) != 2 {
+		t.Log("Expected 2 newly uncovered lines, got", resp.Uncovered)
+		t.Fail()
+	}
+
+	// the lines are not compared with the report of another result
+	obj := &Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Ref: "feature", Commit: "abc1234", Output: true, FinishedAt: time.Now()}
+	obj.Cover, obj.Packages = computeCoverage(headReport.Profiles)
+	saveCover(obj, nil)
+	if resp := compareRefs("github.com/user/repo", "golang-1.10", "", "feature"); resp.Status != compareDone || len(resp.Uncovered) != 0 {
+		t.Log("Expected no uncovered lines from the report of another result, got", resp.Status, resp.Uncovered)
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/compare.svg?tag=1.10&head=feature", nil))
+	if svg := rec.Body.String(); !strings.Contains(svg, ">-41.7%<") || !strings.Contains(svg, badgeColor("red")) {
+		t.Log("Expected a red -41.7% badge, got", svg)
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/compare.json?tag=1.10", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Log("Expected 400 without head, got", rec.Code)
+		t.Fail()
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultURL is the public URL of cover.run if none is configured
+	defaultURL = "https://cover.run"
+	// defaultTimeout is the maximum duration of a cover run if none is configured
+	defaultTimeout = 5 * time.Minute
+	// defaultMemoryBudgetMB is the host memory the cover runs may take together if no budget
+	// is configured, coverQMax runs of the default limits fit in it
+	defaultMemoryBudgetMB = 8 * 1024
+)
+
+// VersionConfig is the configuration of a supported Go version
+type VersionConfig struct {
+	// Version is the Go version, e.g. "1.11"
+	Version string `json:"version"`
+	// Image is the Docker image used to run cover, defaults to avelino/cover.run:golang-{Version}
+	Image string `json:"image,omitempty"`
+	// Aliases are additional names the version can be requested with
+	Aliases []string `json:"aliases,omitempty"`
+	// Limits override the global container limits for the version
+	Limits *ContainerLimits `json:"limits,omitempty"`
+}
+
+// RepoConfig is the configuration of a single repository
+type RepoConfig struct {
+	// Secret is the secret of the repository webhooks, the hooks of a repository without
+	// one are rejected
+	Secret string `json:"secret,omitempty"`
+	// Timeout overrides the global timeout of the cover runs of the repository
+	Timeout string `json:"timeout,omitempty"`
+	// AllowFailures measures the coverage of the repository even if some tests fail
+	AllowFailures bool `json:"allow_failures,omitempty"`
+}
+
+// InstallationConfig is the installation of cover.run on an account of a git host, with
+// which the coverage of the pull requests is reported back to the host
+type InstallationConfig struct {
+	// Provider is the git host, github or gitlab
+	Provider string `json:"provider"`
+	// APIURL is the URL of the REST API of the host, defaults to the API of github.com or
+	// gitlab.com
+	APIURL string `json:"api_url,omitempty"`
+	// Token is the API token of the installation
+	Token string `json:"token"`
+	// Accounts are the users or organizations of the installation, e.g. github.com/avelino
+	Accounts []string `json:"accounts"`
+	// Tag is the Go version the pull requests are measured with, defaults to the latest
+	Tag string `json:"tag,omitempty"`
+	// Comment adds a comment with the coverage summary to the pull requests
+	Comment bool `json:"comment,omitempty"`
+	// Threshold is the coverage drop, in percentage points, above which the status of a pull
+	// request fails
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// Config is the configuration of cover.run, loaded from a JSON file
+type Config struct {
+	// URL is the public URL of cover.run, used in the links posted to the git hosts
+	URL string `json:"url,omitempty"`
+	// Versions are the supported Go versions. If empty, they are discovered from the
+	// local avelino/cover.run:golang-* Docker images.
+	Versions []VersionConfig `json:"versions,omitempty"`
+	// Repos is the configuration of the repositories, by repo name e.g. github.com/user/project
+	Repos map[string]RepoConfig `json:"repos,omitempty"`
+	// Installations are the git host accounts the pull requests are reported to
+	Installations []InstallationConfig `json:"installations,omitempty"`
+	// Timeout is the maximum duration of a cover run, e.g. "10m", defaults to defaultTimeout
+	Timeout string `json:"timeout,omitempty"`
+	// AllowFailures measures the coverage of all the repositories even if some tests fail,
+	// from the packages whose tests passed
+	AllowFailures bool `json:"allow_failures,omitempty"`
+	// Limits are the resource limits of the containers cover is run in, see defaultLimits
+	Limits *ContainerLimits `json:"limits,omitempty"`
+	// MemoryBudgetMB is the host memory the cover runs may take together, with their /tmp and
+	// workspace held in memory, defaults to defaultMemoryBudgetMB. Limits which do not fit
+	// coverQMax runs are rejected.
+	MemoryBudgetMB int64 `json:"memory_budget_mb,omitempty"`
+}
+
+// config is the loaded configuration
+var config = &Config{}
+
+// repoConfig returns the configuration of the given repo, the zero value if it has none
+func (cfg *Config) repoConfig(repo string) RepoConfig {
+	return cfg.Repos[repoRoot(repo)]
+}
+
+// timeout returns the maximum duration of a cover run of the given repo
+func (cfg *Config) timeout(repo string) time.Duration {
+	for _, t := range []string{cfg.repoConfig(repo).Timeout, cfg.Timeout} {
+		if d, err := time.ParseDuration(t); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultTimeout
+}
+
+// maxTimeout returns the longest cover run timeout of all the repositories
+func (cfg *Config) maxTimeout() time.Duration {
+	max := cfg.timeout("")
+	for repo := range cfg.Repos {
+		if d := cfg.timeout(repo); d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// allowFailures returns true if the coverage of the given repo is measured even if some
+// tests fail
+func (cfg *Config) allowFailures(repo string) bool {
+	return cfg.AllowFailures || cfg.repoConfig(repo).AllowFailures
+}
+
+// validate returns an error if a value of the configuration is invalid
+func (cfg *Config) validate() error {
+	timeouts := map[string]string{"timeout": cfg.Timeout}
+	for repo, rc := range cfg.Repos {
+		timeouts[repo+" timeout"] = rc.Timeout
+	}
+	for name, t := range timeouts {
+		if t == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(t); err != nil || d <= 0 {
+			return fmt.Errorf("Invalid %s %q", name, t)
+		}
+	}
+
+	budget := cfg.MemoryBudgetMB
+	if budget <= 0 {
+		budget = defaultMemoryBudgetMB
+	}
+	global := defaultLimits.merge(cfg.Limits)
+	limits := map[string]ContainerLimits{"limits": global}
+	for _, vc := range cfg.Versions {
+		limits[vc.Version+" limits"] = global.merge(vc.Limits)
+	}
+	for name, l := range limits {
+		if need := l.hostMemoryMB() * coverQMax; need > budget {
+			return fmt.Errorf("Invalid %s, %d cover runs take up to %dMB of memory, more than the %dMB of memory_budget_mb", name, coverQMax, need, budget)
+		}
+	}
+	return nil
+}
+
+// installation returns the installation of the account of the given repo, nil if there is none
+func (cfg *Config) installation(repo string) *InstallationConfig {
+	for i, inst := range cfg.Installations {
+		for _, account := range inst.Accounts {
+			if strings.HasPrefix(repo, strings.TrimSuffix(account, "/")+"/") {
+				return &cfg.Installations[i]
+			}
+		}
+	}
+	return nil
+}
+
+// baseURL returns the public URL of cover.run
+func (cfg *Config) baseURL() string {
+	if cfg.URL == "" {
+		return defaultURL
+	}
+	return strings.TrimSuffix(cfg.URL, "/")
+}
+
+// loadConfig reads the configuration from the JSON file at path, an empty path returns
+// the default configuration
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, cfg.validate()
+}
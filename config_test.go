@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	// 5 runs of the default limits take 7.5GB with their tmpfs
+	if err := (&Config{}).validate(); err != nil {
+		t.Log("Expected the default limits to fit the default budget, got", err)
+		t.Fail()
+	}
+	if err := (&Config{MemoryBudgetMB: 4 * 1024}).validate(); err == nil {
+		t.Log("Expected the default limits not to fit 4GB")
+		t.Fail()
+	}
+
+	// the default budget is enforced
+	cfg := &Config{Limits: &ContainerLimits{DiskMB: 1024}}
+	if err := cfg.validate(); err == nil {
+		t.Log("Expected 5 runs of 2.25GB not to fit the default budget")
+		t.Fail()
+	}
+
+	cfg.MemoryBudgetMB = 16 * 1024
+	if err := cfg.validate(); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	cfg.Versions = []VersionConfig{{Version: "1.11", Limits: &ContainerLimits{MemoryMB: 4096}}}
+	if err := cfg.validate(); err == nil {
+		t.Log("Expected the limits of 1.11 not to fit 16GB")
+		t.Fail()
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	oldStore, oldConfig := store, config
+	defer func() {
+		store, config = oldStore, oldConfig
+	}()
+	store = newMemoryStore()
+	config = &Config{
+		Timeout: "10m",
+		Repos:   map[string]RepoConfig{"github.com/user/slow": {Timeout: "30m"}},
+	}
+
+	if err := config.validate(); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	tt := map[string]time.Duration{
+		"github.com/user/repo":     10 * time.Minute,
+		"github.com/user/slow/pkg": 30 * time.Minute,
+	}
+	for repo, expected := range tt {
+		if d := config.timeout(repo); d != expected {
+			t.Log(repo, "expected", expected, "got", d)
+			t.Fail()
+		}
+	}
+	if d := (&Config{}).timeout("github.com/user/repo"); d != defaultTimeout {
+		t.Log("Expected the default timeout, got", d)
+		t.Fail()
+	}
+	if err := (&Config{Timeout: "soon"}).validate(); err == nil {
+		t.Log("Expected an error for an invalid timeout")
+		t.Fail()
+	}
+
+	obj := &Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Cover: ErrTimeout.Error(), Status: StatusTimeout}
+	saveCover(obj, nil)
+	_, color, status := coverageStatus(obj.Repo, obj.Tag, "", nil)
+	if color != "orange" || status != "timeout" {
+		t.Log("Expected an orange timeout badge, got", color, status)
+		t.Fail()
+	}
+	if retryable(ErrTimeout) {
+		t.Log("Expected a timeout not to be retried")
+		t.Fail()
+	}
+}
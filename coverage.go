@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// outputMarker prefixes the lines run.sh writes to stdout to separate the sections
+	// of its output, e.g. "### cover.run profile"
+	outputMarker = "### cover.run "
+	// sectionProfile is the output section holding the contents of coverage.out
+	sectionProfile = "profile"
+	// sectionSource is the output section holding the source of a covered file, the file
+	// name follows the section name, e.g. "### cover.run source github.com/user/repo/main.go"
+	sectionSource = "source"
+	// sectionMeta is the output section holding the details of the run, as key=value lines
+	sectionMeta = "meta"
+
+	// Modes in which the coverage of a repository is measured
+	modeModule = "module"
+	modeGOPATH = "gopath"
+)
+
+// ProfileBlock is a single block of a coverage profile
+type ProfileBlock struct {
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	NumStmt   int
+	Count     int
+}
+
+// Profile holds all the coverage blocks of a single source file
+type Profile struct {
+	FileName string
+	Mode     string
+	Blocks   []ProfileBlock
+}
+
+// PackageCoverage holds the statement coverage of a single package
+type PackageCoverage struct {
+	Name       string
+	Statements int
+	Covered    int
+	Percent    float64
+}
+
+// FileCoverage holds the statement coverage of a single source file
+type FileCoverage struct {
+	Name       string
+	Statements int
+	Covered    int
+	Percent    float64
+}
+
+// runOutput holds the sections of the output written by run.sh to stdout
+type runOutput struct {
+	// Test is the output of go test, as plain text
+	Test string
+	// Events are the go test -json events, if the Go version supports it
+	Events []TestEvent
+	// Profile is the raw coverage profile (coverage.out)
+	Profile string
+	// Sources is the source code of the covered files, by file name
+	Sources map[string]string
+	// Meta holds the details of the run, e.g. "mode" is either modeModule or modeGOPATH
+	Meta map[string]string
+}
+
+// parseRunOutput splits the stdout of a cover run into its sections
+func parseRunOutput(stdOut string) *runOutput {
+	out := &runOutput{
+		Sources: make(map[string]string),
+		Meta:    make(map[string]string),
+	}
+	section, arg := "", ""
+	test := &strings.Builder{}
+	profile := &strings.Builder{}
+	source := &strings.Builder{}
+
+	flushSource := func() {
+		if section == sectionSource && arg != "" {
+			out.Sources[arg] = source.String()
+		}
+		source.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(stdOut, "\n") {
+		if strings.HasPrefix(line, outputMarker) {
+			flushSource()
+			parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, outputMarker)), " ", 2)
+			section, arg = parts[0], ""
+			if len(parts) == 2 {
+				arg = strings.TrimSpace(parts[1])
+			}
+			continue
+		}
+
+		switch section {
+		case sectionProfile:
+			profile.WriteString(line)
+		case sectionSource:
+			source.WriteString(line)
+		case sectionMeta:
+			kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+			if len(kv) == 2 {
+				out.Meta[kv[0]] = kv[1]
+			}
+		default:
+			if ev, ok := parseTestEvent(line); ok {
+				out.Events = append(out.Events, ev)
+				line = ev.Output
+			}
+			test.WriteString(line)
+		}
+	}
+	flushSource()
+
+	out.Test = test.String()
+	out.Profile = profile.String()
+	return out
+}
+
+// testCoverageMatch matches the go test line of a package whose tests passed, with its
+// coverage, e.g. "ok  	github.com/user/repo	0.012s	coverage: 80.0% of statements"
+var testCoverageMatch = regexp.MustCompile(`^ok\s+(\S+)\s+\S+\s+coverage: ([0-9.]+)% of statements`)
+
+// parseTestCoverage returns the coverage of the packages reported by go test, in its plain
+// output. The number of statements is not known, only the percentage is set. It is used
+// when there is no coverage profile, e.g. if the cover run timed out before all the packages
+// were tested.
+func parseTestCoverage(test string) []PackageCoverage {
+	pkgs := make([]PackageCoverage, 0)
+	for _, line := range strings.Split(test, "\n") {
+		m := testCoverageMatch.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		p, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			continue
+		}
+		pkgs = append(pkgs, PackageCoverage{Name: m[1], Percent: p})
+	}
+	return pkgs
+}
+
+// parseProfileLine parses a single block line of a coverage profile.
+// The format of a line is "name.go:line.column,line.column numberOfStatements count"
+func parseProfileLine(line string) (string, ProfileBlock, error) {
+	block := ProfileBlock{}
+	idx := strings.LastIndex(line, ":")
+	if idx < 1 {
+		return "", block, fmt.Errorf("invalid profile line %q", line)
+	}
+	fileName := line[:idx]
+
+	var err error
+	fields := strings.Fields(line[idx+1:])
+	if len(fields) != 3 {
+		return "", block, fmt.Errorf("invalid profile line %q", line)
+	}
+
+	pos := strings.Split(fields[0], ",")
+	if len(pos) != 2 {
+		return "", block, fmt.Errorf("invalid block position in %q", line)
+	}
+	block.StartLine, block.StartCol, err = parseProfilePos(pos[0])
+	if err != nil {
+		return "", block, err
+	}
+	block.EndLine, block.EndCol, err = parseProfilePos(pos[1])
+	if err != nil {
+		return "", block, err
+	}
+
+	block.NumStmt, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return "", block, err
+	}
+	block.Count, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return "", block, err
+	}
+
+	return fileName, block, nil
+}
+
+// parseProfilePos parses a "line.column" position of a profile block
+func parseProfilePos(pos string) (int, int, error) {
+	parts := strings.Split(pos, ".")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid block position %q", pos)
+	}
+	line, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	col, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return line, col, nil
+}
+
+// parseProfiles parses a coverage profile as written by `go test -coverprofile`.
+// Blocks of the same file found more than once (e.g. when a file is covered by the tests
+// of multiple packages) are merged into one.
+func parseProfiles(r io.Reader) ([]*Profile, error) {
+	files := make(map[string]*Profile)
+	mode := ""
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "mode:") {
+			m := strings.TrimSpace(strings.TrimPrefix(line, "mode:"))
+			if mode != "" && m != mode {
+				return nil, fmt.Errorf("inconsistent coverage modes %q and %q", mode, m)
+			}
+			mode = m
+			continue
+		}
+
+		if mode == "" {
+			return nil, fmt.Errorf("missing mode line in coverage profile")
+		}
+
+		fileName, block, err := parseProfileLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		p := files[fileName]
+		if p == nil {
+			p = &Profile{FileName: fileName, Mode: mode}
+			files[fileName] = p
+		}
+		p.Blocks = append(p.Blocks, block)
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*Profile, 0, len(files))
+	for _, p := range files {
+		err := p.mergeBlocks()
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].FileName < profiles[j].FileName
+	})
+
+	return profiles, nil
+}
+
+// mergeBlocks sorts the blocks by position and merges the duplicate ones
+func (p *Profile) mergeBlocks() error {
+	sort.SliceStable(p.Blocks, func(i, j int) bool {
+		bi, bj := p.Blocks[i], p.Blocks[j]
+		if bi.StartLine != bj.StartLine {
+			return bi.StartLine < bj.StartLine
+		}
+		return bi.StartCol < bj.StartCol
+	})
+
+	blocks := p.Blocks[:0]
+	for _, b := range p.Blocks {
+		if len(blocks) == 0 {
+			blocks = append(blocks, b)
+			continue
+		}
+
+		last := &blocks[len(blocks)-1]
+		if b.StartLine != last.StartLine || b.StartCol != last.StartCol ||
+			b.EndLine != last.EndLine || b.EndCol != last.EndCol {
+			blocks = append(blocks, b)
+			continue
+		}
+
+		if b.NumStmt != last.NumStmt {
+			return fmt.Errorf("inconsistent number of statements in %s:%d.%d", p.FileName, b.StartLine, b.StartCol)
+		}
+
+		if p.Mode == "set" {
+			if b.Count > 0 {
+				last.Count = 1
+			}
+		} else {
+			last.Count += b.Count
+		}
+	}
+	p.Blocks = blocks
+	return nil
+}
+
+// Statements returns the total number of statements and the number of covered
+// statements in the file
+func (p *Profile) Statements() (int, int) {
+	total, covered := 0, 0
+	for _, b := range p.Blocks {
+		total += b.NumStmt
+		if b.Count > 0 {
+			covered += b.NumStmt
+		}
+	}
+	return total, covered
+}
+
+// percent returns the covered percentage, rounded to 2 decimals
+func percent(covered, total int) float64 {
+	if total < 1 {
+		return 0
+	}
+	return math.Round(float64(covered)*10000/float64(total)) / 100
+}
+
+// packageCoverage returns the coverage of every package found in the profiles, sorted by
+// package name
+func packageCoverage(profiles []*Profile) []PackageCoverage {
+	pkgs := make(map[string]*PackageCoverage)
+	for _, p := range profiles {
+		name := path.Dir(p.FileName)
+		pkg := pkgs[name]
+		if pkg == nil {
+			pkg = &PackageCoverage{Name: name}
+			pkgs[name] = pkg
+		}
+
+		total, covered := p.Statements()
+		pkg.Statements += total
+		pkg.Covered += covered
+	}
+
+	list := make([]PackageCoverage, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		pkg.Percent = percent(pkg.Covered, pkg.Statements)
+		list = append(list, *pkg)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// fileCoverage returns the coverage of every file found in the profiles, sorted by file name
+func fileCoverage(profiles []*Profile) []FileCoverage {
+	list := make([]FileCoverage, 0, len(profiles))
+	for _, p := range profiles {
+		total, covered := p.Statements()
+		list = append(list, FileCoverage{
+			Name:       p.FileName,
+			Statements: total,
+			Covered:    covered,
+			Percent:    percent(covered, total),
+		})
+	}
+	return list
+}
+
+// totalCoverage returns the number of statements, covered statements and the statement
+// weighted coverage of all the packages
+func totalCoverage(pkgs []PackageCoverage) (int, int, float64) {
+	total, covered := 0, 0
+	for _, pkg := range pkgs {
+		total += pkg.Statements
+		covered += pkg.Covered
+	}
+	return total, covered, percent(covered, total)
+}
+
+// computeCoverage returns the statement weighted total coverage, as reported by
+// `go tool cover -func`, along with the per package breakdown
+func computeCoverage(profiles []*Profile) (string, []PackageCoverage) {
+	total, covered := 0, 0
+	for _, p := range profiles {
+		t, c := p.Statements()
+		total += t
+		covered += c
+	}
+
+	// rounding to 2
+	return fmt.Sprintf("%.2f%%", percent(covered, total)), packageCoverage(profiles)
+}
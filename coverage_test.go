@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testProfile = `mode: count
+github.com/user/repo/small.go:3.10,5.2 1 4
+github.com/user/repo/big/big.go:3.10,5.2 1 1
+github.com/user/repo/big/big.go:7.10,20.2 9 0
+github.com/user/repo/small.go:3.10,5.2 1 2
+`
+
+func TestParseProfiles(t *testing.T) {
+	profiles, err := parseProfiles(strings.NewReader(testProfile))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if len(profiles) != 2 {
+		t.Log("Expected 2 profiles, got", len(profiles))
+		t.FailNow()
+	}
+
+	small := profiles[1]
+	if small.FileName != "github.com/user/repo/small.go" || small.Mode != "count" {
+		t.Log("Unexpected profile", small.FileName, small.Mode)
+		t.Fail()
+	}
+
+	if len(small.Blocks) != 1 || small.Blocks[0].Count != 6 {
+		t.Log("Expected duplicate blocks to be merged, got", small.Blocks)
+		t.Fail()
+	}
+
+	_, err = parseProfiles(strings.NewReader("github.com/user/repo/small.go:3.10,5.2 1 4\n"))
+	if err == nil {
+		t.Log("Expected error for profile without mode")
+		t.Fail()
+	}
+
+	_, err = parseProfiles(strings.NewReader("mode: set\ngithub.com/user/repo/small.go:3.10 1 4\n"))
+	if err == nil {
+		t.Log("Expected error for invalid block")
+		t.Fail()
+	}
+}
+
+func TestComputeCoverage(t *testing.T) {
+	profiles, err := parseProfiles(strings.NewReader(testProfile))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// 2 of 11 statements are covered, an unweighted mean of the packages would be 55%
+	cover, pkgs := computeCoverage(profiles)
+	if cover != "18.18%" {
+		t.Log("Expected 18.18%, got", cover)
+		t.Fail()
+	}
+
+	if len(pkgs) != 2 {
+		t.Log("Expected 2 packages, got", len(pkgs))
+		t.FailNow()
+	}
+
+	if pkgs[0].Name != "github.com/user/repo" || pkgs[0].Statements != 1 || pkgs[0].Covered != 1 || pkgs[0].Percent != 100 {
+		t.Log("Unexpected package coverage", pkgs[0])
+		t.Fail()
+	}
+
+	if pkgs[1].Name != "github.com/user/repo/big" || pkgs[1].Statements != 10 || pkgs[1].Covered != 1 || pkgs[1].Percent != 10 {
+		t.Log("Unexpected package coverage", pkgs[1])
+		t.Fail()
+	}
+
+	cover, pkgs = computeCoverage(nil)
+	if cover != "0.00%" || len(pkgs) != 0 {
+		t.Log("Expected 0.00% and no packages, got", cover, pkgs)
+		t.Fail()
+	}
+}
+
+func TestParseRunOutput(t *testing.T) {
+	out := parseRunOutput("ok  \tgithub.com/user/repo\t0.01s\tcoverage: 100.0% of statements\n" +
+		outputMarker + sectionProfile + "\n" + testProfile)
+
+	if !strings.HasPrefix(out.Test, "ok") || strings.Contains(out.Test, "mode:") {
+		t.Log("Unexpected test output", out.Test)
+		t.Fail()
+	}
+
+	if out.Profile != testProfile {
+		t.Log("Unexpected profile", out.Profile)
+		t.Fail()
+	}
+}
+
+func TestParseTestCoverage(t *testing.T) {
+	test := "ok  \tgithub.com/user/repo\t0.01s\tcoverage: 80.5% of statements\n" +
+		"?   \tgithub.com/user/repo/cmd\t[no test files]\n" +
+		"ok  \tgithub.com/user/repo/pkg\t(cached)\tcoverage: 12.0% of statements\n" +
+		"FAIL\tgithub.com/user/repo/slow\t300.000s\n"
+
+	pkgs := parseTestCoverage(test)
+	expected := []PackageCoverage{
+		{Name: "github.com/user/repo", Percent: 80.5},
+		{Name: "github.com/user/repo/pkg", Percent: 12},
+	}
+	if len(pkgs) != len(expected) {
+		t.Log("Expected", expected, "got", pkgs)
+		t.FailNow()
+	}
+	for i := range expected {
+		if pkgs[i] != expected[i] {
+			t.Log("Expected", expected[i], "got", pkgs[i])
+			t.Fail()
+		}
+	}
+}
+
+func TestParseRunOutputSources(t *testing.T) {
+	out := parseRunOutput(outputMarker + sectionProfile + "\n" + testProfile +
+		outputMarker + sectionSource + " github.com/user/repo/small.go\npackage repo\n\nfunc small() {}\n" +
+		outputMarker + sectionSource + " github.com/user/repo/big/big.go\npackage big\n" +
+		outputMarker + sectionMeta + "\nmode=module\ncommit=0123abc\n")
+
+	if out.Meta["mode"] != modeModule || out.Meta["commit"] != "0123abc" {
+		t.Log("Expected module mode and commit, got", out.Meta)
+		t.Fail()
+	}
+
+	if out.Profile != testProfile {
+		t.Log("Unexpected profile", out.Profile)
+		t.Fail()
+	}
+
+	if out.Sources["github.com/user/repo/small.go"] != "package repo\n\nfunc small() {}\n" {
+		t.Log("Unexpected source", out.Sources["github.com/user/repo/small.go"])
+		t.Fail()
+	}
+
+	if out.Sources["github.com/user/repo/big/big.go"] != "package big\n" {
+		t.Log("Unexpected source", out.Sources["github.com/user/repo/big/big.go"])
+		t.Fail()
+	}
+}
@@ -1,26 +1,160 @@
 #!/bin/bash
 set -e
 
-go get -d -t $1
-cd /go/src/$1
+# Usage: run.sh [fetch|test] repo [ref]
+#
+# The repository and its dependencies are fetched in the fetch phase, which has network
+# access, and tested in the test phase, which has none. Both phases run in their own
+# container sharing the $GOPATH volume. Without a phase, both are run one after the other.
+phase=all
+case "$1" in
+    fetch|test)
+        phase=$1
+        shift
+        ;;
+esac
 
-lines=`go test -covermode=count -coverprofile=coverage.out ./...`
+repo=$1
+# ref is the branch, tag or commit to measure, the default branch if empty
+ref=$2
 
-if [ $? -gt 0 ]; then
-    echo "Error: Cannot test '$1'" >&2
-    exit 2
-fi
+GOPATH=${GOPATH:-/go}
+src=$GOPATH/src
+# state holds the details found by the fetch phase, for the test phase
+state=$GOPATH/.cover-run
 
-if [ ! -f coverage.out ]; then
-    echo "Error: No test files for '$1'" >&2
-    exit 3
-fi
+fetch_repo() {
+    # The repository is cloned from its root, e.g. github.com/user/project for
+    # github.com/user/project/v2 or github.com/user/project/sub/pkg
+    root=`echo $repo | cut -d/ -f1-3`
+    subdir=`echo $repo | cut -s -d/ -f4-`
 
-# html=`go tool cover -html=coverage.out -o=/dev/stdout`
-# | sed s/\"/\'/g)
-if [ $? -gt 0 ]; then
-    echo "Error: Cannot get coverage of '$1'" >&2
-    exit 4
-fi
+    if git clone --quiet "https://$root" "$src/$root" 2>/dev/null; then
+        dir="$src/$root"
+        if [ -n "$subdir" ] && [ -d "$dir/$subdir" ]; then
+            dir="$dir/$subdir"
+        elif [ -n "$subdir" ] && ! echo "$subdir" | grep -q '^v[0-9][0-9]*$'; then
+            # a /vN suffix without a matching directory is a major version kept at the root
+            echo "Error: Cannot find '$repo'" >&2
+            exit 1
+        fi
+    else
+        # not a plain git repository (e.g. a vanity import path), fetched in GOPATH mode
+        GO111MODULE=off go get -d -t $repo
+        dir="$src/$repo"
+    fi
+    cd $dir
 
-echo $lines
+    if [ -n "$ref" ]; then
+        # the ref may be a branch or tag not fetched by the clone, or a commit SHA
+        git fetch --quiet --tags origin "$ref" 2>/dev/null || true
+        if ! git checkout --quiet "$ref" 2>/dev/null && ! git checkout --quiet FETCH_HEAD 2>/dev/null; then
+            echo "Error: Cannot find ref '$ref' of '$repo'" >&2
+            exit 1
+        fi
+    fi
+
+    # Modules are used if there is a go.mod in the directory or any of its parents in the
+    # repository, and the Go version supports them
+    mod=""
+    d=$dir
+    while [ "$d" != "$src" ] && [ "$d" != "/" ]; do
+        if [ -f "$d/go.mod" ]; then
+            mod=$d
+            break
+        fi
+        d=`dirname $d`
+    done
+
+    if [ -n "$mod" ] && go help mod >/dev/null 2>&1; then
+        mode="module"
+        if [ ! -d "$mod/vendor" ]; then
+            (cd $mod && GO111MODULE=on go mod download)
+        fi
+    else
+        mode="gopath"
+        GO111MODULE=off go get -d -t ./...
+    fi
+
+    echo "dir=$dir" > $state
+    echo "mod=$mod" >> $state
+    echo "mode=$mode" >> $state
+}
+
+test_repo() {
+    . $state
+    cd $dir
+
+    if [ "$mode" = "module" ]; then
+        export GO111MODULE=on
+        # everything was downloaded in the fetch phase
+        export GOPROXY=off
+        if [ -d "$mod/vendor" ]; then
+            export GOFLAGS=-mod=vendor
+        fi
+    else
+        export GO111MODULE=off
+    fi
+
+    # The output of go test is written as the packages are tested, so the coverage of the
+    # packages tested before a timeout is kept. It is written as JSON events if the Go version
+    # supports it, to find the failing tests.
+    json=""
+    if go help test 2>&1 | grep -q -- '-json'; then
+        json="-json"
+    fi
+
+    # With COVER_ALLOW_FAILURES, the coverage of the packages whose tests passed is kept
+    tests=passed
+    if ! go test $json -covermode=count -coverprofile=coverage.out ./...; then
+        if [ -z "$COVER_ALLOW_FAILURES" ] || [ ! -f coverage.out ]; then
+            echo "Error: Cannot test '$repo'" >&2
+            exit 2
+        fi
+        tests=failed
+    fi
+
+    if [ ! -f coverage.out ]; then
+        echo "Error: No test files for '$repo'" >&2
+        exit 3
+    fi
+
+    # The coverage profile is written after a marker line, so that the statement weighted
+    # coverage can be computed from it
+    echo "### cover.run profile"
+    cat coverage.out
+
+    # Source of the covered files, used to render the HTML coverage report. The files are
+    # found from the directories of their packages, which do not match the import paths in
+    # module mode.
+    go list -f '{{.ImportPath}} {{.Dir}}' ./... > /tmp/packages
+    for file in `tail -n +2 coverage.out | cut -d: -f1 | sort -u`; do
+        pkgdir=`awk -v pkg="${file%/*}" '$1 == pkg { print $2 }' /tmp/packages`
+        file_src="$pkgdir/${file##*/}"
+        if [ -n "$pkgdir" ] && [ -f "$file_src" ]; then
+            echo "### cover.run source $file"
+            # make sure the file ends with a new line, so the next marker is on its own line
+            sed -e '$a\' "$file_src"
+        fi
+    done
+
+    # Details of the run, as key=value
+    echo "### cover.run meta"
+    echo "mode=$mode"
+    echo "tests=$tests"
+    echo "commit=`git rev-parse HEAD 2>/dev/null`"
+}
+
+case $phase in
+    fetch)
+        fetch_repo
+        ;;
+    test)
+        test_repo
+        ;;
+    *)
+        # the fetch phase runs in a subshell, as it changes the directory
+        (fetch_repo)
+        test_repo
+        ;;
+esac
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Actions of the go test -json events used
+	testActionPass = "pass"
+	testActionFail = "fail"
+	testActionSkip = "skip"
+
+	// slowestMax is the maximum number of slowest tests listed, per package and in total
+	slowestMax = 5
+)
+
+// TestEvent is an event written by `go test -json`, see `go doc test2json`
+type TestEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// parseTestEvent parses a line of the output of go test, it returns false if the line is
+// not a go test -json event
+func parseTestEvent(line string) (TestEvent, bool) {
+	ev := TestEvent{}
+	if !strings.HasPrefix(line, "{") {
+		return ev, false
+	}
+	err := json.Unmarshal([]byte(line), &ev)
+	return ev, err == nil && ev.Action != ""
+}
+
+// TestDuration is the elapsed time of a test, in seconds
+type TestDuration struct {
+	Package string `json:",omitempty"`
+	Name    string
+	Elapsed float64
+}
+
+// TestCounts are the numbers of tests which passed, failed and were skipped, subtests are
+// counted with their parent
+type TestCounts struct {
+	Total   int
+	Passed  int
+	Failed  int
+	Skipped int
+}
+
+// add counts the result of a test
+func (tc *TestCounts) add(action string) {
+	switch action {
+	case testActionPass:
+		tc.Passed++
+	case testActionFail:
+		tc.Failed++
+	case testActionSkip:
+		tc.Skipped++
+	default:
+		return
+	}
+	tc.Total++
+}
+
+// PackageTests are the test results of a package
+type PackageTests struct {
+	Name string
+	TestCounts
+	// Result is the result of the package, pass, fail or skip if it has no tests
+	Result string
+	// Elapsed is the time taken by the tests of the package, in seconds
+	Elapsed float64
+	// Slowest are the slowest tests of the package
+	Slowest []TestDuration
+}
+
+// TestSummary are the test results of all the packages of a repository
+type TestSummary struct {
+	TestCounts
+	// Elapsed is the total time taken by the tests of all the packages, in seconds
+	Elapsed  float64
+	Packages []PackageTests
+	// Slowest are the slowest tests of all the packages
+	Slowest []TestDuration
+}
+
+// slowest returns the n slowest tests, the slowest first
+func slowest(tests []TestDuration, n int) []TestDuration {
+	sorted := make([]TestDuration, len(tests))
+	copy(sorted, tests)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Elapsed > sorted[j].Elapsed
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// testSummary returns the test results of the go test -json events, nil if there are none
+func testSummary(events []TestEvent) *TestSummary {
+	if len(events) == 0 {
+		return nil
+	}
+
+	pkgs := make(map[string]*PackageTests)
+	durations := make(map[string][]TestDuration)
+	for _, ev := range events {
+		if ev.Package == "" {
+			continue
+		}
+		pkg := pkgs[ev.Package]
+		if pkg == nil {
+			pkg = &PackageTests{Name: ev.Package}
+			pkgs[ev.Package] = pkg
+		}
+
+		switch {
+		case ev.Test == "":
+			// the end of the package
+			if ev.Action == testActionPass || ev.Action == testActionFail || ev.Action == testActionSkip {
+				pkg.Result = ev.Action
+				pkg.Elapsed = ev.Elapsed
+			}
+		case strings.Contains(ev.Test, "/"):
+			// subtests are counted with their parent
+		default:
+			before := pkg.Total
+			pkg.add(ev.Action)
+			if pkg.Total > before {
+				durations[ev.Package] = append(durations[ev.Package], TestDuration{Name: ev.Test, Elapsed: ev.Elapsed})
+			}
+		}
+	}
+
+	summary := &TestSummary{Packages: make([]PackageTests, 0, len(pkgs))}
+	for name, pkg := range pkgs {
+		pkg.Slowest = slowest(durations[name], slowestMax)
+		summary.Packages = append(summary.Packages, *pkg)
+	}
+	sort.Slice(summary.Packages, func(i, j int) bool {
+		return summary.Packages[i].Name < summary.Packages[j].Name
+	})
+
+	all := make([]TestDuration, 0)
+	for _, pkg := range summary.Packages {
+		summary.Total += pkg.Total
+		summary.Passed += pkg.Passed
+		summary.Failed += pkg.Failed
+		summary.Skipped += pkg.Skipped
+		summary.Elapsed += pkg.Elapsed
+		for _, d := range durations[pkg.Name] {
+			d.Package = pkg.Name
+			all = append(all, d)
+		}
+	}
+	summary.Elapsed = math.Round(summary.Elapsed*1000) / 1000
+	summary.Slowest = slowest(all, slowestMax)
+	return summary
+}
+
+// FailedPackage is a package whose tests failed, with the names of its failing tests
+type FailedPackage struct {
+	Name string
+	// Tests are the failing tests, empty if the package failed to build or the tests
+	// failed outside of a test function, e.g. in TestMain
+	Tests []string
+}
+
+// failedPackages returns the packages whose tests failed, sorted by name. The failing tests
+// are found in the go test -json events, the packages only in the plain output of Go versions
+// without -json.
+func failedPackages(events []TestEvent, test string) []FailedPackage {
+	tests := make(map[string][]string)
+	for _, ev := range events {
+		if ev.Action != testActionFail || ev.Package == "" {
+			continue
+		}
+		if _, ok := tests[ev.Package]; !ok {
+			tests[ev.Package] = make([]string, 0)
+		}
+		// subtests are listed with their parent
+		if ev.Test != "" && !strings.Contains(ev.Test, "/") {
+			tests[ev.Package] = append(tests[ev.Package], ev.Test)
+		}
+	}
+
+	if len(events) == 0 {
+		for _, line := range strings.Split(test, "\n") {
+			// e.g. "FAIL	github.com/user/repo	0.012s"
+			fields := strings.Fields(line)
+			if len(fields) > 1 && fields[0] == "FAIL" {
+				tests[fields[1]] = make([]string, 0)
+			}
+		}
+	}
+
+	pkgs := make([]FailedPackage, 0, len(tests))
+	for name, names := range tests {
+		sort.Strings(names)
+		pkgs = append(pkgs, FailedPackage{Name: name, Tests: names})
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].Name < pkgs[j].Name
+	})
+	return pkgs
+}
+
+// failingTests returns the number of failing tests, a package which failed without a
+// failing test counts as one
+func failingTests(pkgs []FailedPackage) int {
+	n := 0
+	for _, pkg := range pkgs {
+		if len(pkg.Tests) == 0 {
+			n++
+		}
+		n += len(pkg.Tests)
+	}
+	return n
+}
+
+// passedProfiles returns the profiles of the files which are not in the failed packages
+func passedProfiles(profiles []*Profile, failed []FailedPackage) []*Profile {
+	names := make(map[string]bool)
+	for _, pkg := range failed {
+		names[pkg.Name] = true
+	}
+
+	passed := make([]*Profile, 0, len(profiles))
+	for _, p := range profiles {
+		if !names[path.Dir(p.FileName)] {
+			passed = append(passed, p)
+		}
+	}
+	return passed
+}
+
+// testsStatus returns the color and status of the tests badge of a repository, e.g.
+// "120 passed" or "2 failed, 118 passed"
+func testsStatus(repo, tag, ref string) (string, string) {
+	obj, color, status := coverageStatus(repo, tag, ref, nil)
+	if obj.Tests == nil {
+		if obj.Status == StatusQueued || obj.Status == StatusRunning {
+			return color, status
+		}
+		if obj.Status == StatusNoTests {
+			return "lightgrey", "no tests"
+		}
+		return "lightgrey", "unknown"
+	}
+
+	parts := make([]string, 0, 3)
+	if obj.Tests.Failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", obj.Tests.Failed))
+	}
+	parts = append(parts, fmt.Sprintf("%d passed", obj.Tests.Passed))
+	if obj.Tests.Skipped > 0 {
+		parts = append(parts, fmt.Sprintf("%d skipped", obj.Tests.Skipped))
+	}
+
+	color = "green"
+	if obj.Tests.Failed > 0 {
+		color = "red"
+	}
+	return color, strings.Join(parts, ", ")
+}
+
+// HandlerRepoTests returns the SVG badge with the test results of a repository, e.g.
+// "tests 120 passed", `label` replaces the tests label and `logo` adds a logo
+func HandlerRepoTests(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+	if tag == "" {
+		tag = DefaultTag
+	}
+	repo := strings.TrimSpace(mux.Vars(r)["repo"])
+
+	badgeStyle := parseBadgeStyle(r.URL.Query().Get("style"))
+
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+
+	label := strings.TrimSpace(r.URL.Query().Get("label"))
+	if label == "" {
+		label = "tests"
+	}
+
+	logo, err := parseBadgeLogo(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	color, status := testsStatus(repo, tag, ref)
+	svg := getLabelBadge(label, color, badgeStyle, status, logo)
+
+	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
+	w.Header().Set("pragma", "no-cache")
+	w.Header().Set("expires", "-1")
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	w.Write([]byte(svg))
+}
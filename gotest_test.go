@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTestsStatus(t *testing.T) {
+	oldStore, oldQueue := store, queue
+	defer func() {
+		store, queue = oldStore, oldQueue
+	}()
+	store = newMemoryStore()
+	queue = newLocalQueue(store)
+
+	saveCover(&Object{Repo: "github.com/user/passed", Tag: "golang-1.10", Cover: "80.00%", Output: true, Status: StatusPassed,
+		Tests: &TestSummary{TestCounts: TestCounts{Total: 121, Passed: 120, Skipped: 1}}}, nil)
+	saveCover(&Object{Repo: "github.com/user/failed", Tag: "golang-1.10", Cover: "80.00%", Output: true, Status: StatusTestsFailed,
+		Tests: &TestSummary{TestCounts: TestCounts{Total: 120, Passed: 118, Failed: 2}}}, nil)
+	saveCover(&Object{Repo: "github.com/user/old", Tag: "golang-1.10", Cover: "80.00%", Output: true, Status: StatusPassed}, nil)
+	saveCover(&Object{Repo: "github.com/user/none", Tag: "golang-1.10", Cover: ErrNoTest.Error(), Status: StatusNoTests}, nil)
+
+	tt := map[string][2]string{
+		"github.com/user/passed": {"green", "120 passed, 1 skipped"},
+		"github.com/user/failed": {"red", "2 failed, 118 passed"},
+		"github.com/user/old":    {"lightgrey", "unknown"},
+		"github.com/user/none":   {"lightgrey", "no tests"},
+		"github.com/user/new":    {"lightgrey", "queued"},
+	}
+	for repo, expected := range tt {
+		if color, status := testsStatus(repo, "1.10", ""); color != expected[0] || status != expected[1] {
+			t.Log(repo, "expected", expected, "got", color, status)
+			t.Fail()
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/go/{repo:.*}/tests.svg", HandlerRepoTests)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/failed/tests.svg?tag=1.10", nil))
+	svg := rec.Body.String()
+	if !strings.Contains(svg, ">tests<") || !strings.Contains(svg, ">2 failed, 118 passed<") || !strings.Contains(svg, `fill="#d6604a"`) {
+		t.Log("Expected a red tests badge, got", svg)
+		t.Fail()
+	}
+}
+
+func TestFailedPackages(t *testing.T) {
+	stdOut := `{"Action":"run","Package":"github.com/user/repo","Test":"TestA"}
+{"Action":"output","Package":"github.com/user/repo","Test":"TestA","Output":"--- FAIL: TestA (0.00s)\n"}
+{"Action":"fail","Package":"github.com/user/repo","Test":"TestA/sub","Elapsed":0}
+{"Action":"fail","Package":"github.com/user/repo","Test":"TestA","Elapsed":0}
+{"Action":"fail","Package":"github.com/user/repo","Elapsed":0.01}
+{"Action":"output","Package":"github.com/user/repo/pkg","Output":"ok  \tgithub.com/user/repo/pkg\t0.01s\tcoverage: 50.0% of statements\n"}
+{"Action":"pass","Package":"github.com/user/repo/pkg","Elapsed":0.01}
+{"Action":"fail","Package":"github.com/user/repo/build","Elapsed":0}
+` + outputMarker + sectionProfile + "\n" + testProfile + outputMarker + sectionMeta + "\ntests=failed\n"
+
+	out := parseRunOutput(stdOut)
+	if len(out.Events) != 8 || !strings.Contains(out.Test, "--- FAIL: TestA") || out.Meta["tests"] != "failed" {
+		t.Log("Unexpected output", len(out.Events), out.Test, out.Meta)
+		t.FailNow()
+	}
+	if pkgs := parseTestCoverage(out.Test); len(pkgs) != 1 || pkgs[0].Percent != 50 {
+		t.Log("Expected the coverage of the passed package, got", pkgs)
+		t.Fail()
+	}
+
+	failed := failedPackages(out.Events, out.Test)
+	expected := []FailedPackage{
+		{Name: "github.com/user/repo", Tests: []string{"TestA"}},
+		{Name: "github.com/user/repo/build", Tests: []string{}},
+	}
+	if len(failed) != len(expected) {
+		t.Log("Expected", expected, "got", failed)
+		t.FailNow()
+	}
+	for i := range expected {
+		if failed[i].Name != expected[i].Name || strings.Join(failed[i].Tests, ",") != strings.Join(expected[i].Tests, ",") {
+			t.Log("Expected", expected[i], "got", failed[i])
+			t.Fail()
+		}
+	}
+	if n := failingTests(failed); n != 2 {
+		t.Log("Expected 2 failing tests, got", n)
+		t.Fail()
+	}
+
+	// without -json, only the failing packages are known
+	failed = failedPackages(nil, "--- FAIL: TestA (0.00s)\nFAIL\nFAIL\tgithub.com/user/repo\t0.01s\n")
+	if len(failed) != 1 || failed[0].Name != "github.com/user/repo" {
+		t.Log("Expected the failed package, got", failed)
+		t.Fail()
+	}
+
+	profiles, err := parseProfiles(strings.NewReader(out.Profile))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	profiles = append(profiles, &Profile{FileName: "github.com/user/repo/pkg/pkg.go"})
+	passed := passedProfiles(profiles, failed)
+	if len(passed) != 2 || passed[0].FileName != "github.com/user/repo/big/big.go" || passed[1].FileName != "github.com/user/repo/pkg/pkg.go" {
+		t.Log("Expected only the profiles of the passed packages, got", len(passed))
+		t.Fail()
+	}
+
+	obj := &Object{Cover: "50.00%", Output: true, Status: StatusTestsFailed, Failures: failed}
+	if color, status := failingStatus(obj, "yellow", obj.Cover); color != "red" || status != "50.00%, 1 failing" {
+		t.Log("Unexpected failing badge", color, status)
+		t.Fail()
+	}
+}
+
+func TestTestSummary(t *testing.T) {
+	events := make([]TestEvent, 0)
+	for _, line := range strings.Split(`{"Action":"run","Package":"github.com/user/repo","Test":"TestSlow"}
+{"Action":"pass","Package":"github.com/user/repo","Test":"TestSlow/sub","Elapsed":1.2}
+{"Action":"pass","Package":"github.com/user/repo","Test":"TestSlow","Elapsed":1.5}
+{"Action":"fail","Package":"github.com/user/repo","Test":"TestFast","Elapsed":0.01}
+{"Action":"skip","Package":"github.com/user/repo","Test":"TestSkipped","Elapsed":0}
+{"Action":"fail","Package":"github.com/user/repo","Elapsed":1.6}
+{"Action":"pass","Package":"github.com/user/repo/pkg","Test":"TestPkg","Elapsed":0.2}
+{"Action":"pass","Package":"github.com/user/repo/pkg","Elapsed":0.25}
+{"Action":"skip","Package":"github.com/user/repo/cmd","Elapsed":0}`, "\n") {
+		ev, ok := parseTestEvent(line)
+		if !ok {
+			t.Log("Cannot parse", line)
+			t.FailNow()
+		}
+		events = append(events, ev)
+	}
+
+	summary := testSummary(events)
+	expected := TestCounts{Total: 4, Passed: 2, Failed: 1, Skipped: 1}
+	if summary.TestCounts != expected || summary.Elapsed != 1.85 || len(summary.Packages) != 3 {
+		t.Log("Expected", expected, "got", summary.TestCounts, summary.Elapsed, len(summary.Packages))
+		t.FailNow()
+	}
+
+	repo := summary.Packages[0]
+	if repo.Name != "github.com/user/repo" || repo.Result != testActionFail || repo.Total != 3 || repo.Elapsed != 1.6 {
+		t.Log("Unexpected package", repo)
+		t.Fail()
+	}
+	if cmd := summary.Packages[1]; cmd.Name != "github.com/user/repo/cmd" || cmd.Result != testActionSkip || cmd.Total != 0 {
+		t.Log("Unexpected package without tests", cmd)
+		t.Fail()
+	}
+	if len(summary.Slowest) != 4 || summary.Slowest[0].Name != "TestSlow" || summary.Slowest[1].Package != "github.com/user/repo/pkg" {
+		t.Log("Unexpected slowest tests", summary.Slowest)
+		t.Fail()
+	}
+
+	if testSummary(nil) != nil {
+		t.Log("Expected no summary without events")
+		t.Fail()
+	}
+
+	oldStore := store
+	defer func() {
+		store = oldStore
+	}()
+	store = newMemoryStore()
+	saveCover(&Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Cover: "50.00%", Output: true, Tests: summary}, nil)
+	if color, status := testsStatus("github.com/user/repo", "1.10", ""); color != "red" || status != "1 failed, 2 passed, 1 skipped" {
+		t.Log("Unexpected tests badge", color, status)
+		t.Fail()
+	}
+}
@@ -8,7 +8,8 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// HandlerRepoJSON returns the coverage details of a repository as JSON
+// HandlerRepoJSON returns the coverage details of a repository as JSON, the per file
+// coverage is included when `detail=files` is set
 func HandlerRepoJSON(w http.ResponseWriter, r *http.Request) {
 	goversion := strings.TrimSpace(r.URL.Query().Get("tag"))
 	if goversion == "" {
@@ -18,11 +19,19 @@ func HandlerRepoJSON(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	repo := strings.TrimSpace(vars["repo"])
 
-	obj, _ := repoCover(repo, goversion)
-	json.NewEncoder(w).Encode(obj)
+	detail := strings.TrimSpace(r.URL.Query().Get("detail"))
+
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+
+	obj, _ := repoCover(repo, goversion, ref)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newRepoResponse(obj, detail))
 }
 
-// HandlerRepoSVG returns the SVG badge with coverage for a given repository
+// HandlerRepoSVG returns the SVG badge with coverage for a given repository, `failing=1`
+// adds the number of failing tests to a coverage measured despite failing tests, and `label`,
+// `color` and `thresholds` customize the badge
 func HandlerRepoSVG(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
@@ -31,12 +40,19 @@ func HandlerRepoSVG(w http.ResponseWriter, r *http.Request) {
 	}
 	repo := strings.TrimSpace(vars["repo"])
 
-	badgeStyle := strings.TrimSpace(r.URL.Query().Get("style"))
-	if badgeStyle != "flat" {
-		badgeStyle = "flat-square"
+	badgeStyle := parseBadgeStyle(r.URL.Query().Get("style"))
+
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+
+	failing := r.URL.Query().Get("failing") != ""
+
+	opts, err := parseBadgeOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	svg, _ := coverageBadge(repo, tag, badgeStyle)
+	svg, _ := coverageBadge(repo, tag, ref, badgeStyle, failing, opts)
 
 	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
 	w.Header().Set("pragma", "no-cache")
@@ -47,12 +63,54 @@ func HandlerRepoSVG(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(svg))
 }
 
+// HandlerRepoHTML returns the HTML coverage report of a repository, with the covered and
+// uncovered lines of every file
+func HandlerRepoHTML(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+	if tag == "" {
+		tag = DefaultTag
+	}
+	repo := strings.TrimSpace(vars["repo"])
+
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+
+	obj, err := repoCover(repo, tag, ref)
+	var report *Report
+	if err == nil {
+		report, err = repoReport(repo, obj.Tag, ref)
+		if err != nil && err != ErrNotFound {
+			errLogger.Println(err)
+		}
+		// the report of a previous run is not shown with the result of the current one
+		if report != nil && !report.matches(obj) {
+			report = nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = reportTmpl.Execute(w, newReportPage(obj, report))
+	if err != nil {
+		errLogger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // HandlerBadge generates a badge with the given value
 func HandlerBadge(w http.ResponseWriter, r *http.Request) {
 	style := strings.TrimSpace(r.URL.Query().Get("style"))
 	color := strings.TrimSpace(r.URL.Query().Get("color"))
 	value := strings.TrimSpace(r.URL.Query().Get("value"))
-	svg := getBadge(color, style, value)
+	label := strings.TrimSpace(r.URL.Query().Get("label"))
+	if label == "" {
+		label = defaultLabel
+	}
+	logo, err := parseBadgeLogo(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	svg := getLabelBadge(label, color, style, value, logo)
 
 	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
 	w.Header().Set("pragma", "no-cache")
@@ -62,6 +120,12 @@ func HandlerBadge(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(svg))
 }
 
+// HandlerVersions returns the supported Go versions as JSON, newest first
+func HandlerVersions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions.List())
+}
+
 // Handler returns the homepage
 func Handler(w http.ResponseWriter, r *http.Request) {
 	err := pageTmpl.Execute(w, nil)
@@ -70,3 +134,76 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// HandlerBadgePNG generates a PNG badge with the given value, `scale` is the scale of the
+// image, e.g. 2 for retina displays
+func HandlerBadgePNG(w http.ResponseWriter, r *http.Request) {
+	style := strings.TrimSpace(r.URL.Query().Get("style"))
+	color := strings.TrimSpace(r.URL.Query().Get("color"))
+	value := strings.TrimSpace(r.URL.Query().Get("value"))
+	label := strings.TrimSpace(r.URL.Query().Get("label"))
+	if label == "" {
+		label = defaultLabel
+	}
+	logo, err := parseBadgeLogo(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scale, err := parseBadgeScale(r.URL.Query().Get("scale"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	img, err := getLabelBadgePNG(label, color, style, value, logo, scale)
+	if err != nil {
+		errLogger.Println(err)
+		http.Error(w, ErrUnknown.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
+	w.Header().Set("pragma", "no-cache")
+	w.Header().Set("expires", "-1")
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(img)
+}
+
+// HandlerRepoPNG returns the PNG badge with coverage for a given repository, with the
+// parameters of the SVG badge and `scale`
+func HandlerRepoPNG(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+	if tag == "" {
+		tag = DefaultTag
+	}
+	repo := strings.TrimSpace(mux.Vars(r)["repo"])
+	badgeStyle := parseBadgeStyle(r.URL.Query().Get("style"))
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+	failing := r.URL.Query().Get("failing") != ""
+
+	opts, err := parseBadgeOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scale, err := parseBadgeScale(r.URL.Query().Get("scale"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	color, status := coverageBadgeStatus(repo, tag, ref, failing, opts)
+	img, err := getLabelBadgePNG(opts.label(), color, badgeStyle, status, opts.Logo, scale)
+	if err != nil {
+		errLogger.Println(err)
+		http.Error(w, ErrUnknown.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
+	w.Header().Set("pragma", "no-cache")
+	w.Header().Set("expires", "-1")
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(img)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// historyMax is the maximum number of results kept in the history of a repo + tag + ref
+	historyMax = 1000
+	// historyLimit is the number of results returned by the history API if no limit is given
+	historyLimit = 100
+
+	// historyDate is the short date format accepted by the from and to parameters
+	historyDate = "2006-01-02"
+)
+
+var (
+	// ErrInvalidRange is the error returned for invalid from, to or range parameters
+	ErrInvalidRange = errors.New("Invalid time range")
+	// ErrInvalidLimit is the error returned for an invalid limit parameter
+	ErrInvalidLimit = errors.New("Invalid limit")
+)
+
+// HistoryEntry is a single result in the coverage history of a repo + tag + ref
+type HistoryEntry struct {
+	Time   time.Time
+	Commit string
+	// Cover is the coverage as shown in the badge
+	Cover string
+	// Percent is the statement weighted coverage of all packages
+	Percent    float64
+	Statements int
+	Covered    int
+	// Packages is the number of packages measured
+	Packages int
+}
+
+// newHistoryEntry returns the history entry of the given result
+func newHistoryEntry(obj *Object, t time.Time) *HistoryEntry {
+	entry := &HistoryEntry{
+		Time:     t,
+		Commit:   obj.Commit,
+		Cover:    obj.Cover,
+		Packages: len(obj.Packages),
+	}
+	for _, pkg := range obj.Packages {
+		entry.Statements += pkg.Statements
+		entry.Covered += pkg.Covered
+	}
+	entry.Percent = percent(entry.Covered, entry.Statements)
+	return entry
+}
+
+// inRange returns true if t is within from and to, a zero from or to is unbounded
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+}
+
+// repoHistory returns up to limit of the most recent results of a repo + tag + ref between
+// from and to, the oldest first
+func repoHistory(repo, tag, ref string, from, to time.Time, limit int) ([]*HistoryEntry, error) {
+	return store.History(repoFullName(repo, tag, ref), from, to, limit)
+}
+
+// parseHistoryTime parses a from or to parameter, either an RFC 3339 time or a date. The
+// end of the day is returned for a to date, so that the whole day is included.
+func parseHistoryTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(historyDate, value)
+	if err != nil {
+		return time.Time{}, ErrInvalidRange
+	}
+	if end {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// parseRange parses a range parameter, a duration such as 12h or a number of days such as 30d
+func parseRange(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days < 1 {
+			return 0, ErrInvalidRange
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, ErrInvalidRange
+	}
+	return d, nil
+}
+
+// historyParams returns the time range and limit of a history request
+// - from and to limit the results to a time range, as RFC 3339 times or dates
+// - range is the time range up to now, e.g. 30d or 12h, if from is not given
+// - limit is the maximum number of results, the most recent are returned
+func historyParams(r *http.Request) (from, to time.Time, limit int, err error) {
+	query := r.URL.Query()
+
+	from, err = parseHistoryTime(strings.TrimSpace(query.Get("from")), false)
+	if err != nil {
+		return
+	}
+	to, err = parseHistoryTime(strings.TrimSpace(query.Get("to")), true)
+	if err != nil {
+		return
+	}
+
+	if value := strings.TrimSpace(query.Get("range")); value != "" && from.IsZero() {
+		var d time.Duration
+		d, err = parseRange(value)
+		if err != nil {
+			return
+		}
+		from = time.Now().Add(-d)
+	}
+
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		err = ErrInvalidRange
+		return
+	}
+
+	limit = historyLimit
+	if value := strings.TrimSpace(query.Get("limit")); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			err = ErrInvalidLimit
+			return
+		}
+	}
+	if limit > historyMax {
+		limit = historyMax
+	}
+	return
+}
+
+// HistoryResponse is the JSON response with the coverage history of a repository
+type HistoryResponse struct {
+	Repo    string
+	Tag     string
+	Ref     string
+	History []*HistoryEntry
+}
+
+// HandlerRepoHistory returns the coverage history of a repository as JSON, the oldest
+// result first
+func HandlerRepoHistory(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+	if tag == "" {
+		tag = DefaultTag
+	}
+	repo := strings.TrimSpace(mux.Vars(r)["repo"])
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+
+	v, ok := versions.Resolve(tag)
+	if !ok {
+		http.Error(w, ErrImgUnSupported.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validRef(ref) {
+		http.Error(w, ErrInvalidRef.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, to, limit, err := historyParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := repoHistory(repo, v.Name, ref, from, to, limit)
+	if err != nil {
+		errLogger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range history {
+		entry.Time = entry.Time.UTC()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&HistoryResponse{
+		Repo:    repo,
+		Tag:     v.Name,
+		Ref:     ref,
+		History: history,
+	})
+}
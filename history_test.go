@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHistoryParams(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/history.json?from=2018-01-01&to=2018-01-31&limit=10", nil)
+	from, to, limit, err := historyParams(req)
+	if err != nil || !from.Equal(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)) ||
+		!to.Equal(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)) || limit != 10 {
+		t.Log("Unexpected params", from, to, limit, err)
+		t.Fail()
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/history.json?range=7d", nil)
+	from, _, limit, err = historyParams(req)
+	if err != nil || time.Since(from) < 7*24*time.Hour-time.Minute || limit != historyLimit {
+		t.Log("Unexpected params", from, limit, err)
+		t.Fail()
+	}
+
+	invalid := []string{"from=yesterday", "range=-1d", "limit=0", "from=2018-02-01&to=2018-01-01"}
+	for _, query := range invalid {
+		req = httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/history.json?"+query, nil)
+		_, _, _, err = historyParams(req)
+		if err == nil {
+			t.Log("Expected an error for", query)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerRepoHistory(t *testing.T) {
+	oldStore := store
+	defer func() { store = oldStore }()
+	store = newMemoryStore()
+
+	obj := &Object{
+		Repo:   "github.com/user/repo",
+		Tag:    "golang-1.10",
+		Cover:  "50.00%",
+		Output: true,
+		Commit: "0123abc",
+		Packages: []PackageCoverage{
+			{Name: "github.com/user/repo", Statements: 10, Covered: 4},
+			{Name: "github.com/user/repo/sub", Statements: 10, Covered: 6},
+		},
+	}
+	saveCover(obj, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/go/{repo:.*}/history.json", HandlerRepoHistory)
+	r.HandleFunc("/go/{repo:.*}.json", HandlerRepoJSON)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/history.json?tag=1.10", nil))
+	if rec.Code != http.StatusOK {
+		t.Log("Expected 200, got", rec.Code)
+		t.FailNow()
+	}
+
+	resp := &HistoryResponse{}
+	err := json.NewDecoder(rec.Body).Decode(resp)
+	if err != nil || resp.Repo != "github.com/user/repo" || resp.Tag != "golang-1.10" || len(resp.History) != 1 {
+		t.Log("Unexpected response", resp, err)
+		t.FailNow()
+	}
+
+	entry := resp.History[0]
+	if entry.Commit != "0123abc" || entry.Percent != 50 || entry.Statements != 20 || entry.Packages != 2 {
+		t.Log("Unexpected history entry", entry)
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/history.json?limit=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Log("Expected 400, got", rec.Code)
+		t.Fail()
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Supported webhook providers, the {provider} of /hooks/{provider}
+	hookGitHub = "github"
+	hookGitLab = "gitlab"
+	hookGitea  = "gitea"
+
+	// hookBodyMax is the maximum size of a webhook payload
+	hookBodyMax = 5 << 20
+
+	// Prefixes of the pushed git refs
+	refHeads = "refs/heads/"
+	refTags  = "refs/tags/"
+)
+
+var (
+	// ErrHookProvider is the error returned for a webhook of an unknown provider
+	ErrHookProvider = errors.New("Unsupported webhook provider")
+	// ErrHookSignature is the error returned when the signature of a webhook does not match
+	// the secret of the repository
+	ErrHookSignature = errors.New("Invalid webhook signature")
+	// ErrHookNoSecret is the error returned for a webhook of a repository without a secret
+	ErrHookNoSecret = errors.New("Webhooks are not enabled for the repository")
+	// ErrHookPayload is the error returned when a webhook payload cannot be parsed
+	ErrHookPayload = errors.New("Invalid webhook payload")
+)
+
+// hookProvider is how a git hosting service sends its webhooks
+type hookProvider struct {
+	// eventHeader is the header with the name of the event
+	eventHeader string
+	// pushEvents are the names of the push and tag push events
+	pushEvents []string
+	// pullEvents are the names of the pull request events, only the providers which the
+	// coverage is reported to have them
+	pullEvents []string
+	// verify returns true if the request is signed with the secret
+	verify func(r *http.Request, body []byte, secret string) bool
+}
+
+// hookProviders are the supported webhook providers
+var hookProviders = map[string]*hookProvider{
+	hookGitHub: {
+		eventHeader: "X-GitHub-Event",
+		pushEvents:  []string{"push"},
+		pullEvents:  []string{"pull_request"},
+		verify: func(r *http.Request, body []byte, secret string) bool {
+			if sig := r.Header.Get("X-Hub-Signature-256"); sig != "" {
+				return validHMAC(sha256.New, body, secret, strings.TrimPrefix(sig, "sha256="))
+			}
+			sig := r.Header.Get("X-Hub-Signature")
+			return strings.HasPrefix(sig, "sha1=") &&
+				validHMAC(sha1.New, body, secret, strings.TrimPrefix(sig, "sha1="))
+		},
+	},
+	hookGitLab: {
+		eventHeader: "X-Gitlab-Event",
+		pushEvents:  []string{"Push Hook", "Tag Push Hook"},
+		pullEvents:  []string{"Merge Request Hook"},
+		// GitLab does not sign its webhooks, it sends the secret token as is
+		verify: func(r *http.Request, body []byte, secret string) bool {
+			return hmac.Equal([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret))
+		},
+	},
+	hookGitea: {
+		eventHeader: "X-Gitea-Event",
+		pushEvents:  []string{"push"},
+		verify: func(r *http.Request, body []byte, secret string) bool {
+			return validHMAC(sha256.New, body, secret, r.Header.Get("X-Gitea-Signature"))
+		},
+	},
+}
+
+// validHMAC returns true if the hex signature is the HMAC of the body with the secret
+func validHMAC(h func() hash.Hash, body []byte, secret, signature string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// hookPayload holds the fields of a push event, which are the same for all the providers
+// but for the repository, which GitLab calls project
+type hookPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		HTMLURL       string `json:"html_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Project struct {
+		WebURL        string `json:"web_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"project"`
+}
+
+// hookPush is a push of a branch or tag
+type hookPush struct {
+	Repo string
+	// Ref is the pushed branch or tag, empty for the default branch
+	Ref    string
+	Commit string
+	// Deleted is true if the branch or tag was deleted
+	Deleted bool
+}
+
+// parseHookPayload returns the push of the webhook payload
+func parseHookPayload(body []byte) (*hookPush, error) {
+	payload := &hookPayload{}
+	err := json.Unmarshal(body, payload)
+	if err != nil {
+		return nil, ErrHookPayload
+	}
+
+	repoURL, defaultBranch := payload.Repository.HTMLURL, payload.Repository.DefaultBranch
+	if payload.Project.WebURL != "" {
+		repoURL, defaultBranch = payload.Project.WebURL, payload.Project.DefaultBranch
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return nil, ErrHookPayload
+	}
+
+	push := &hookPush{
+		Repo:   u.Host + strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git"),
+		Commit: payload.After,
+		// GitLab and Gitea send a zero commit when a ref is deleted
+		Deleted: payload.Deleted || strings.Trim(payload.After, "0") == "",
+	}
+
+	switch {
+	case strings.HasPrefix(payload.Ref, refHeads):
+		push.Ref = strings.TrimPrefix(payload.Ref, refHeads)
+		if push.Ref == defaultBranch {
+			push.Ref = ""
+		}
+	case strings.HasPrefix(payload.Ref, refTags):
+		push.Ref = strings.TrimPrefix(payload.Ref, refTags)
+	default:
+		return nil, ErrHookPayload
+	}
+
+	if !validRef(push.Ref) {
+		return nil, ErrInvalidRef
+	}
+	return push, nil
+}
+
+// outdateCover marks the saved results of the repo + ref as outdated for all the Go
+// versions. They are served as stale, with their reports, until they are measured again.
+func outdateCover(repo, ref string) {
+	for _, v := range versions.List() {
+		key := repoFullName(repo, v.Name, ref)
+		obj := &Object{}
+		err := store.Get(key, obj)
+		if err != nil {
+			if err != ErrNotFound {
+				errLogger.Println(err)
+			}
+			continue
+		}
+		// the push is measured even if the last refresh failed
+		obj.Outdated, obj.LastAttemptAt = true, time.Time{}
+		err = store.Set(key, obj, resultRetention)
+		if err != nil {
+			errLogger.Println(err)
+		}
+	}
+}
+
+// queueHookPush marks the saved results of the push as outdated and queues cover runs of the
+// pushed ref for all the Go versions, a ref being measured is measured again once the run is
+// done. It returns the queued Go versions.
+func queueHookPush(push *hookPush) ([]string, error) {
+	outdateCover(push.Repo, push.Ref)
+
+	tags := make([]string, 0)
+	for _, v := range versions.List() {
+		err := addToQ(push.Repo, v.Name, push.Ref)
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, v.Name)
+	}
+	return tags, nil
+}
+
+// HandlerHook receives the webhooks of GitHub, GitLab and Gitea. The coverage of a pushed
+// branch or tag is measured again with all the Go versions, the coverage of a pull request
+// is measured and reported back to GitHub or GitLab.
+func HandlerHook(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := hookProviders[name]
+	if !ok {
+		http.Error(w, ErrHookProvider.Error(), http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, hookBodyMax))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event := r.Header.Get(provider.eventHeader)
+	var (
+		repo    string
+		push    *hookPush
+		pr      *PullRequest
+		measure bool
+	)
+	switch {
+	case hookEvent(provider.pushEvents, event):
+		push, err = parseHookPayload(body)
+		if err == nil {
+			repo, measure = push.Repo, !push.Deleted
+		}
+	case hookEvent(provider.pullEvents, event):
+		pr, measure, err = parsePullPayload(name, body)
+		if err == nil {
+			repo = pr.Repo
+		}
+	default:
+		// other events, e.g. GitHub's ping, are acknowledged as is
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the repository is known only once the payload is parsed, the secret is checked
+	// before anything is done with it
+	secret := config.repoConfig(repo).Secret
+	if secret == "" {
+		http.Error(w, ErrHookNoSecret.Error(), http.StatusForbidden)
+		return
+	}
+	if !provider.verify(r, body, secret) {
+		http.Error(w, ErrHookSignature.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if !measure {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if pr != nil {
+		err = queuePullRequest(pr)
+		if err != nil {
+			errLogger.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(pr)
+		return
+	}
+
+	tags, err := queueHookPush(push)
+	if err != nil {
+		errLogger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		Repo   string
+		Ref    string
+		Commit string
+		Tags   []string
+	}{push.Repo, push.Ref, push.Commit, tags})
+}
+
+// hookEvent returns true if the event is one of the given events
+func hookEvent(events []string, event string) bool {
+	for _, e := range events {
+		if event == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	testGitHubPush = `{"ref":"refs/heads/master","after":"0123abc","deleted":false,"repository":{"html_url":"https://github.com/user/repo","default_branch":"master"}}`
+	testGitLabTag  = `{"ref":"refs/tags/v1.0.0","after":"4567def","project":{"web_url":"https://gitlab.com/user/repo","default_branch":"master"},"repository":{"homepage":"https://gitlab.com/user/repo"}}`
+	testGiteaPush  = `{"ref":"refs/heads/feature/x","after":"0000000000000000000000000000000000000000","repository":{"html_url":"https://gitea.example.com/user/repo/","default_branch":"master"}}`
+)
+
+func TestParseHookPayload(t *testing.T) {
+	tt := map[string]hookPush{
+		testGitHubPush: {Repo: "github.com/user/repo", Ref: "", Commit: "0123abc"},
+		testGitLabTag:  {Repo: "gitlab.com/user/repo", Ref: "v1.0.0", Commit: "4567def"},
+		testGiteaPush:  {Repo: "gitea.example.com/user/repo", Ref: "feature/x", Commit: "0000000000000000000000000000000000000000", Deleted: true},
+	}
+	for payload, expected := range tt {
+		push, err := parseHookPayload([]byte(payload))
+		if err != nil || *push != expected {
+			t.Log("Expected", expected, "got", push, err)
+			t.Fail()
+		}
+	}
+
+	_, err := parseHookPayload([]byte(`{"zen":"Keep it logically awesome."}`))
+	if err != ErrHookPayload {
+		t.Log("Expected", ErrHookPayload, "got", err)
+		t.Fail()
+	}
+}
+
+func TestHandlerHook(t *testing.T) {
+	oldStore, oldQueue, oldConfig := store, queue, config
+	defer func() {
+		store, queue, config = oldStore, oldQueue, oldConfig
+	}()
+	store = newMemoryStore()
+	lq := newLocalQueue(store)
+	queue = lq
+	config = &Config{Repos: map[string]RepoConfig{"github.com/user/repo": {Secret: "s3cr3t"}}}
+
+	store.Set(repoFullName("github.com/user/repo", "golang-1.10", ""), &Object{Cover: "10.00%", FinishedAt: time.Now()}, time.Hour)
+	store.Set(reportKey("github.com/user/repo", "golang-1.10", ""), &Report{}, time.Hour)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/hooks/{provider}", HandlerHook).Methods(http.MethodPost)
+
+	hook := func(provider, event, signature, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost/hooks/"+provider, bytes.NewBufferString(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", signature)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(testGitHubPush))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if code := hook("bitbucket", "push", signature, testGitHubPush); code != http.StatusNotFound {
+		t.Log("Expected", http.StatusNotFound, "for an unknown provider, got", code)
+		t.Fail()
+	}
+
+	if code := hook(hookGitHub, "push", "sha256=00", testGitHubPush); code != http.StatusUnauthorized {
+		t.Log("Expected", http.StatusUnauthorized, "for a bad signature, got", code)
+		t.Fail()
+	}
+
+	if code := hook(hookGitHub, "push", signature, testGitLabTag); code != http.StatusForbidden {
+		t.Log("Expected", http.StatusForbidden, "for a repo without secret, got", code)
+		t.Fail()
+	}
+
+	if len(lq.state.Jobs) != 0 {
+		t.Log("Expected no job to be queued, got", lq.state.Jobs)
+		t.Fail()
+	}
+
+	if code := hook(hookGitHub, "push", signature, testGitHubPush); code != http.StatusAccepted {
+		t.Log("Expected", http.StatusAccepted, "got", code)
+		t.Fail()
+	}
+
+	if len(lq.state.Jobs) != len(versions.List()) {
+		t.Log("Expected a job for every Go version, got", lq.state.Jobs)
+		t.Fail()
+	}
+
+	// the last result and its report are served as stale until they are measured again
+	obj := &Object{}
+	err := store.Get(repoFullName("github.com/user/repo", "golang-1.10", ""), obj)
+	if err != nil || obj.Cover != "10.00%" || !obj.Outdated || !obj.stale(time.Now()) {
+		t.Log("Expected the last result to be kept as stale, got", obj, err)
+		t.Fail()
+	}
+	if _, err := repoReport("github.com/user/repo", "golang-1.10", ""); err != nil {
+		t.Log("Expected the last report to be kept, got", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// logoDataURIPrefix is the prefix of the SVG logos given as a data URI
+	logoDataURIPrefix = "data:image/svg+xml;base64,"
+	// logoMaxSize is the maximum size of a given SVG logo, in bytes
+	logoMaxSize = 16 * 1024
+	// logoWidth is the default width of the logos, logoMaxWidth the maximum, in pixels
+	logoWidth    = 14
+	logoMaxWidth = 40
+	// logoHeight is the height of the logos in the badges, in pixels
+	logoHeight = 14
+	// logoPadding is the space between the logo and the label, in pixels
+	logoPadding = 3
+	// logoColor is the default color of the built-in logos, socialLogoColor the one of the
+	// social style
+	logoColor       = "#fff"
+	socialLogoColor = "#333"
+
+	svgNamespace = "http://www.w3.org/2000/svg"
+)
+
+var (
+	// ErrInvalidLogo is the error returned when the logo is neither a built-in logo nor an
+	// SVG data URI
+	ErrInvalidLogo = errors.New("Invalid logo")
+
+	// builtinLogos are the SVG paths of the built-in logos, in a 24x24 box
+	builtinLogos = map[string]string{
+		"go":     `<circle cx="4" cy="5" r="2.5"/><circle cx="20" cy="5" r="2.5"/><path d="M12 2C6.5 2 4 6 4 11v11h16V11c0-5-2.5-9-8-9z"/><g fill="#fff"><circle cx="8.5" cy="8.5" r="2.5"/><circle cx="15.5" cy="8.5" r="2.5"/><rect x="10.8" y="13" width="2.4" height="2.2" rx=".4"/></g><g fill="#333"><circle cx="9" cy="8.7" r="1"/><circle cx="16" cy="8.7" r="1"/><ellipse cx="12" cy="12.3" rx="1.6" ry="1"/></g>`,
+		"github": `<path d="M12 .297c-6.63 0-12 5.373-12 12 0 5.303 3.438 9.8 8.205 11.385.6.113.82-.258.82-.577 0-.285-.01-1.04-.015-2.04-3.338.724-4.042-1.61-4.042-1.61C4.422 18.07 3.633 17.7 3.633 17.7c-1.087-.744.084-.729.084-.729 1.205.084 1.838 1.236 1.838 1.236 1.07 1.835 2.809 1.305 3.495.998.108-.776.417-1.305.76-1.605-2.665-.3-5.466-1.332-5.466-5.93 0-1.31.465-2.38 1.235-3.22-.135-.303-.54-1.523.105-3.176 0 0 1.005-.322 3.3 1.23.96-.267 1.98-.399 3-.405 1.02.006 2.04.138 3 .405 2.28-1.552 3.285-1.23 3.285-1.23.645 1.653.24 2.873.12 3.176.765.84 1.23 1.91 1.23 3.22 0 4.61-2.805 5.625-5.475 5.92.42.36.81 1.096.81 2.22 0 1.606-.015 2.896-.015 3.286 0 .315.21.69.825.57C20.565 22.092 24 17.592 24 12.297c0-6.627-5.373-12-12-12"/>`,
+		"gitlab": `<path d="M23.955 13.587l-1.342-4.135-2.664-8.189c-.135-.423-.73-.423-.867 0L16.418 9.45H7.582L4.919 1.263c-.135-.423-.73-.423-.867 0L1.388 9.452.046 13.587c-.121.375.014.789.331 1.023L12 23.054l11.623-8.443c.318-.235.453-.647.332-1.024"/>`,
+	}
+	// logoAliases are the other names of the built-in logos
+	logoAliases = map[string]string{
+		"gopher": "go",
+		"golang": "go",
+	}
+
+	// logoElements are the SVG elements kept in a given logo, the others are removed with
+	// their content
+	logoElements = map[string]bool{
+		"svg": true, "g": true, "defs": true, "path": true, "circle": true, "ellipse": true,
+		"rect": true, "line": true, "polyline": true, "polygon": true, "linearGradient": true,
+		"radialGradient": true, "stop": true, "clipPath": true,
+	}
+	// logoAttrs are the attributes kept in a given logo, there are no event handlers, links
+	// nor styles
+	logoAttrs = map[string]bool{
+		"viewBox": true, "width": true, "height": true, "preserveAspectRatio": true, "version": true,
+		"id": true, "d": true, "x": true, "y": true, "x1": true, "y1": true, "x2": true, "y2": true,
+		"cx": true, "cy": true, "r": true, "rx": true, "ry": true, "fx": true, "fy": true,
+		"points": true, "transform": true, "opacity": true, "fill": true, "fill-opacity": true,
+		"fill-rule": true, "stroke": true, "stroke-width": true, "stroke-opacity": true,
+		"stroke-linecap": true, "stroke-linejoin": true, "stroke-miterlimit": true,
+		"clip-path": true, "clip-rule": true, "offset": true, "stop-color": true,
+		"stop-opacity": true, "gradientUnits": true, "gradientTransform": true,
+		"spreadMethod": true,
+	}
+	// externalURLMatch matches the references to anything else than an element of the logo,
+	// e.g. `url(https://example.com/a.svg)`
+	externalURLMatch = regexp.MustCompile(`(?i)url\s*\(\s*['"]?\s*[^#'"\s]`)
+)
+
+// badgeLogo is the logo drawn before the label of a badge
+type badgeLogo struct {
+	// Name is the name of the built-in logo, empty for a given one
+	Name string
+	// SVG is the sanitized given logo
+	SVG string
+	// Color is the color of the built-in logo, the default one if empty
+	Color string
+	Width int
+}
+
+// parseBadgeLogo returns the logo of the `logo`, `logoColor` and `logoWidth` query string
+// parameters, nil if there is none. The logo is the name of a built-in logo (`go`, `github`
+// or `gitlab`) or a base64 SVG data URI.
+func parseBadgeLogo(query url.Values) (*badgeLogo, error) {
+	name := strings.TrimSpace(query.Get("logo"))
+	if name == "" {
+		return nil, nil
+	}
+
+	logo := &badgeLogo{Width: logoWidth}
+	if strings.HasPrefix(name, logoDataURIPrefix) {
+		// the + of the base64 data are spaces if they are not escaped in the query string
+		data := strings.Replace(strings.TrimPrefix(name, logoDataURIPrefix), " ", "+", -1)
+		svg, err := base64.StdEncoding.DecodeString(data)
+		if err != nil || len(svg) > logoMaxSize {
+			return nil, ErrInvalidLogo
+		}
+		logo.SVG, err = sanitizeSVG(svg)
+		if err != nil {
+			return nil, ErrInvalidLogo
+		}
+	} else {
+		logo.Name = strings.ToLower(name)
+		if alias, ok := logoAliases[logo.Name]; ok {
+			logo.Name = alias
+		}
+		if _, ok := builtinLogos[logo.Name]; !ok {
+			return nil, ErrInvalidLogo
+		}
+	}
+
+	if color := strings.TrimSpace(query.Get("logoColor")); color != "" {
+		if !validColor(color) {
+			return nil, ErrInvalidColor
+		}
+		logo.Color = badgeColor(color)
+	}
+	if width := strings.TrimSpace(query.Get("logoWidth")); width != "" {
+		w, err := strconv.Atoi(width)
+		if err != nil || w < 1 || w > logoMaxWidth {
+			return nil, ErrInvalidLogo
+		}
+		logo.Width = w
+	}
+	return logo, nil
+}
+
+// svg returns the SVG of the logo, the built-in logos are drawn with the logo color or the
+// default color of the style
+func (logo *badgeLogo) svg(style string) string {
+	if logo.Name == "" {
+		return logo.SVG
+	}
+	color := logo.Color
+	if color == "" {
+		color = logoColor
+		if style == "social" {
+			color = socialLogoColor
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="%s" viewBox="0 0 24 24"><g fill="%s">%s</g></svg>`, svgNamespace, color, builtinLogos[logo.Name])
+}
+
+// dataURI returns the logo as a base64 SVG data URI, empty if there is no logo
+func (logo *badgeLogo) dataURI(style string) string {
+	if logo == nil {
+		return ""
+	}
+	return logoDataURIPrefix + base64.StdEncoding.EncodeToString([]byte(logo.svg(style)))
+}
+
+// sanitizeSVG returns the SVG with only the drawing elements and attributes, so no script,
+// event handler or external reference is kept. The root element must be an svg element.
+func sanitizeSVG(data []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	buf := new(bytes.Buffer)
+	// depth is the depth of the current element, skip the depth of the removed element whose
+	// content is skipped
+	depth, skip := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 && (t.Name.Local != "svg" || (t.Name.Space != "" && t.Name.Space != svgNamespace)) {
+				return "", ErrInvalidLogo
+			}
+			if skip > 0 {
+				continue
+			}
+			if !logoElements[t.Name.Local] || (t.Name.Space != "" && t.Name.Space != svgNamespace) {
+				skip = depth
+				continue
+			}
+			buf.WriteString("<" + t.Name.Local)
+			if depth == 1 {
+				buf.WriteString(` xmlns="` + svgNamespace + `"`)
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Space != "" || !logoAttrs[attr.Name.Local] || externalURLMatch.MatchString(attr.Value) {
+					continue
+				}
+				buf.WriteString(" " + attr.Name.Local + `="`)
+				xml.EscapeText(buf, []byte(attr.Value))
+				buf.WriteString(`"`)
+			}
+			buf.WriteString(">")
+		case xml.EndElement:
+			if skip == 0 {
+				buf.WriteString("</" + t.Name.Local + ">")
+			}
+			if skip == depth {
+				skip = 0
+			}
+			depth--
+		}
+	}
+	if buf.Len() == 0 {
+		return "", ErrInvalidLogo
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"encoding/xml"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/vector"
+)
+
+const (
+	// kappa is the distance of the control points of the cubic Bézier curves drawing a
+	// quarter of a circle of radius 1
+	kappa = 0.5522847498
+	// svgImageWidth and svgImageHeight are the size of an SVG image without viewBox nor size
+	svgImageWidth  = 300
+	svgImageHeight = 150
+)
+
+var (
+	// logoContainers are the elements of a logo whose content is drawn, logoShapes the
+	// elements drawn. The content of the other elements, e.g. defs or gradients, is not drawn.
+	logoContainers = map[string]bool{"svg": true, "g": true}
+	logoShapes     = map[string]bool{
+		"path": true, "circle": true, "ellipse": true, "rect": true, "polygon": true, "polyline": true,
+	}
+
+	// transformMatch matches the transform functions of a transform attribute,
+	// e.g. `translate(2 3)`
+	transformMatch = regexp.MustCompile(`(matrix|translate|scale|rotate|skewX|skewY)\s*\(([^)]*)\)`)
+)
+
+// affine is the SVG transform matrix [a c e; b d f], it maps x, y to a*x+c*y+e, b*x+d*y+f
+type affine [6]float64
+
+// mul returns the transform of n followed by m
+func (m affine) mul(n affine) affine {
+	return affine{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+// apply returns the point x, y transformed by m
+func (m affine) apply(x, y float64) (float32, float32) {
+	return float32(m[0]*x + m[2]*y + m[4]), float32(m[1]*x + m[3]*y + m[5])
+}
+
+// parseTransform returns the matrix of a transform attribute, the unknown transforms are
+// ignored
+func parseTransform(transform string) affine {
+	m := affine{1, 0, 0, 1, 0, 0}
+	for _, match := range transformMatch.FindAllStringSubmatch(transform, -1) {
+		sc := &svgScanner{s: match[2]}
+		var args []float64
+		for {
+			n, ok := sc.number()
+			if !ok {
+				break
+			}
+			args = append(args, n)
+		}
+		arg := func(i int, def float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return def
+		}
+
+		var n affine
+		switch match[1] {
+		case "matrix":
+			if len(args) != 6 {
+				continue
+			}
+			copy(n[:], args)
+		case "translate":
+			n = affine{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			n = affine{arg(0, 1), 0, 0, arg(1, arg(0, 1)), 0, 0}
+		case "rotate":
+			a := arg(0, 0) * math.Pi / 180
+			cx, cy := arg(1, 0), arg(2, 0)
+			sin, cos := math.Sincos(a)
+			n = affine{1, 0, 0, 1, cx, cy}.mul(affine{cos, sin, -sin, cos, 0, 0}).mul(affine{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			n = affine{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			n = affine{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		}
+		m = m.mul(n)
+	}
+	return m
+}
+
+// svgScanner scans the commands, numbers and flags of a path data or of a list of numbers
+type svgScanner struct {
+	s string
+	i int
+}
+
+func (sc *svgScanner) skip() {
+	for sc.i < len(sc.s) && strings.IndexByte(" \t\r\n,", sc.s[sc.i]) >= 0 {
+		sc.i++
+	}
+}
+
+// command returns the next path command, false if the next token is not a command
+func (sc *svgScanner) command() (byte, bool) {
+	sc.skip()
+	if sc.i >= len(sc.s) {
+		return 0, false
+	}
+	c := sc.s[sc.i]
+	if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') || c == 'e' || c == 'E' {
+		return 0, false
+	}
+	sc.i++
+	return c, true
+}
+
+// number returns the next number, false if there is none
+func (sc *svgScanner) number() (float64, bool) {
+	sc.skip()
+	start := sc.i
+	digits := func() int {
+		n := 0
+		for sc.i < len(sc.s) && sc.s[sc.i] >= '0' && sc.s[sc.i] <= '9' {
+			sc.i++
+			n++
+		}
+		return n
+	}
+	if sc.i < len(sc.s) && (sc.s[sc.i] == '-' || sc.s[sc.i] == '+') {
+		sc.i++
+	}
+	n := digits()
+	if sc.i < len(sc.s) && sc.s[sc.i] == '.' {
+		sc.i++
+		n += digits()
+	}
+	if n == 0 {
+		sc.i = start
+		return 0, false
+	}
+	if sc.i < len(sc.s) && (sc.s[sc.i] == 'e' || sc.s[sc.i] == 'E') {
+		exp := sc.i
+		sc.i++
+		if sc.i < len(sc.s) && (sc.s[sc.i] == '-' || sc.s[sc.i] == '+') {
+			sc.i++
+		}
+		if digits() == 0 {
+			sc.i = exp
+		}
+	}
+	v, err := strconv.ParseFloat(sc.s[start:sc.i], 64)
+	return v, err == nil
+}
+
+// flag returns the next arc flag, which may not be separated from the next number
+func (sc *svgScanner) flag() (bool, bool) {
+	sc.skip()
+	if sc.i >= len(sc.s) || (sc.s[sc.i] != '0' && sc.s[sc.i] != '1') {
+		return false, false
+	}
+	sc.i++
+	return sc.s[sc.i-1] == '1', true
+}
+
+// numbers returns the n next numbers, false if there are less
+func (sc *svgScanner) numbers(n int) ([]float64, bool) {
+	nums := make([]float64, n)
+	for i := range nums {
+		v, ok := sc.number()
+		if !ok {
+			return nil, false
+		}
+		nums[i] = v
+	}
+	return nums, true
+}
+
+// logoPath draws the subpaths of a shape in a rasterizer, the points are transformed by m.
+// The subpaths are closed as they are filled.
+type logoPath struct {
+	z *vector.Rasterizer
+	m affine
+	// open is true if a subpath is started, x, y is the current point and sx, sy the start of
+	// the subpath
+	open   bool
+	x, y   float64
+	sx, sy float64
+}
+
+func (p *logoPath) moveTo(x, y float64) {
+	p.close()
+	p.z.MoveTo(p.m.apply(x, y))
+	p.open, p.x, p.y, p.sx, p.sy = true, x, y, x, y
+}
+
+func (p *logoPath) lineTo(x, y float64) {
+	if !p.open {
+		p.moveTo(p.x, p.y)
+	}
+	p.z.LineTo(p.m.apply(x, y))
+	p.x, p.y = x, y
+}
+
+func (p *logoPath) quadTo(x1, y1, x, y float64) {
+	if !p.open {
+		p.moveTo(p.x, p.y)
+	}
+	bx, by := p.m.apply(x1, y1)
+	cx, cy := p.m.apply(x, y)
+	p.z.QuadTo(bx, by, cx, cy)
+	p.x, p.y = x, y
+}
+
+func (p *logoPath) cubeTo(x1, y1, x2, y2, x, y float64) {
+	if !p.open {
+		p.moveTo(p.x, p.y)
+	}
+	bx, by := p.m.apply(x1, y1)
+	cx, cy := p.m.apply(x2, y2)
+	dx, dy := p.m.apply(x, y)
+	p.z.CubeTo(bx, by, cx, cy, dx, dy)
+	p.x, p.y = x, y
+}
+
+// close closes the current subpath, the current point is moved to its start
+func (p *logoPath) close() {
+	if p.open {
+		p.z.ClosePath()
+		p.open, p.x, p.y = false, p.sx, p.sy
+	}
+}
+
+// arcTo draws the elliptical arc of the SVG path data to x, y with cubic Bézier curves of at
+// most a quarter of the ellipse each
+func (p *logoPath) arcTo(rx, ry, rotation float64, large, sweep bool, x, y float64) {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		p.lineTo(x, y)
+		return
+	}
+	if x == p.x && y == p.y {
+		return
+	}
+
+	// the center parameterization of the arc, https://www.w3.org/TR/SVG/implnote.html
+	sin, cos := math.Sincos(rotation * math.Pi / 180)
+	dx, dy := (p.x-x)/2, (p.y-y)/2
+	x1, y1 := cos*dx+sin*dy, -sin*dx+cos*dy
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	k := math.Sqrt(math.Max(num, 0) / den)
+	if large == sweep {
+		k = -k
+	}
+	cx1, cy1 := k*rx*y1/ry, -k*ry*x1/rx
+	cx, cy := cos*cx1-sin*cy1+(p.x+x)/2, sin*cx1+cos*cy1+(p.y+y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	// point returns the point of the ellipse at the angle t, and its derivative
+	point := func(t float64) (float64, float64, float64, float64) {
+		st, ct := math.Sincos(t)
+		return cx + rx*ct*cos - ry*st*sin, cy + rx*ct*sin + ry*st*cos,
+			-rx*st*cos - ry*ct*sin, -rx*st*sin + ry*ct*cos
+	}
+	n := int(math.Ceil(math.Abs(delta) / (math.Pi / 2)))
+	step := delta / float64(n)
+	alpha := 4 / 3.0 * math.Tan(step/4)
+	for i := 0; i < n; i++ {
+		t0, t1 := theta+float64(i)*step, theta+float64(i+1)*step
+		ax, ay, adx, ady := point(t0)
+		bx, by, bdx, bdy := point(t1)
+		if i == n-1 {
+			bx, by = x, y
+		}
+		p.cubeTo(ax+alpha*adx, ay+alpha*ady, bx-alpha*bdx, by-alpha*bdy, bx, by)
+	}
+}
+
+// ellipse draws the ellipse centered on cx, cy
+func (p *logoPath) ellipse(cx, cy, rx, ry float64) {
+	kx, ky := rx*kappa, ry*kappa
+	p.moveTo(cx+rx, cy)
+	p.cubeTo(cx+rx, cy+ky, cx+kx, cy+ry, cx, cy+ry)
+	p.cubeTo(cx-kx, cy+ry, cx-rx, cy+ky, cx-rx, cy)
+	p.cubeTo(cx-rx, cy-ky, cx-kx, cy-ry, cx, cy-ry)
+	p.cubeTo(cx+kx, cy-ry, cx+rx, cy-ky, cx+rx, cy)
+	p.close()
+}
+
+// rect draws the rectangle, its corners are rounded with the radii rx and ry
+func (p *logoPath) rect(x, y, w, h, rx, ry float64) {
+	rx, ry = math.Min(rx, w/2), math.Min(ry, h/2)
+	kx, ky := rx*(1-kappa), ry*(1-kappa)
+	p.moveTo(x+rx, y)
+	p.lineTo(x+w-rx, y)
+	if rx > 0 && ry > 0 {
+		p.cubeTo(x+w-kx, y, x+w, y+ky, x+w, y+ry)
+	}
+	p.lineTo(x+w, y+h-ry)
+	if rx > 0 && ry > 0 {
+		p.cubeTo(x+w, y+h-ky, x+w-kx, y+h, x+w-rx, y+h)
+	}
+	p.lineTo(x+rx, y+h)
+	if rx > 0 && ry > 0 {
+		p.cubeTo(x+kx, y+h, x, y+h-ky, x, y+h-ry)
+	}
+	p.lineTo(x, y+ry)
+	if rx > 0 && ry > 0 {
+		p.cubeTo(x, y+ky, x+kx, y, x+rx, y)
+	}
+	p.close()
+}
+
+// path draws the path data of a path element, the path is drawn up to the first error as
+// in the browsers
+func (p *logoPath) path(d string) {
+	sc := &svgScanner{s: d}
+	var cmd byte
+	// cx, cy is the last control point of a curve, reflected by the smooth curves, it is the
+	// current point after the other commands
+	var cx, cy float64
+	for {
+		sc.skip()
+		if sc.i >= len(sc.s) {
+			break
+		}
+		if c, ok := sc.command(); ok {
+			cmd = c
+		} else if cmd == 0 || cmd == 'z' || cmd == 'Z' {
+			break
+		}
+
+		// ox, oy is the origin of the relative coordinates
+		ox, oy := 0.0, 0.0
+		if cmd >= 'a' {
+			ox, oy = p.x, p.y
+		}
+		switch cmd {
+		case 'M', 'm':
+			n, ok := sc.numbers(2)
+			if !ok {
+				return
+			}
+			p.moveTo(ox+n[0], oy+n[1])
+			// the next coordinates are the ones of lines, L or l
+			cmd--
+		case 'L', 'l':
+			n, ok := sc.numbers(2)
+			if !ok {
+				return
+			}
+			p.lineTo(ox+n[0], oy+n[1])
+		case 'H', 'h':
+			n, ok := sc.numbers(1)
+			if !ok {
+				return
+			}
+			p.lineTo(ox+n[0], p.y)
+		case 'V', 'v':
+			n, ok := sc.numbers(1)
+			if !ok {
+				return
+			}
+			p.lineTo(p.x, oy+n[0])
+		case 'C', 'c':
+			n, ok := sc.numbers(6)
+			if !ok {
+				return
+			}
+			p.cubeTo(ox+n[0], oy+n[1], ox+n[2], oy+n[3], ox+n[4], oy+n[5])
+			cx, cy = ox+n[2], oy+n[3]
+			continue
+		case 'S', 's':
+			n, ok := sc.numbers(4)
+			if !ok {
+				return
+			}
+			x1, y1 := 2*p.x-cx, 2*p.y-cy
+			p.cubeTo(x1, y1, ox+n[0], oy+n[1], ox+n[2], oy+n[3])
+			cx, cy = ox+n[0], oy+n[1]
+			continue
+		case 'Q', 'q':
+			n, ok := sc.numbers(4)
+			if !ok {
+				return
+			}
+			p.quadTo(ox+n[0], oy+n[1], ox+n[2], oy+n[3])
+			cx, cy = ox+n[0], oy+n[1]
+			continue
+		case 'T', 't':
+			n, ok := sc.numbers(2)
+			if !ok {
+				return
+			}
+			x1, y1 := 2*p.x-cx, 2*p.y-cy
+			p.quadTo(x1, y1, ox+n[0], oy+n[1])
+			cx, cy = x1, y1
+			continue
+		case 'A', 'a':
+			n, ok := sc.numbers(3)
+			if !ok {
+				return
+			}
+			large, ok := sc.flag()
+			if !ok {
+				return
+			}
+			sweep, ok := sc.flag()
+			if !ok {
+				return
+			}
+			end, ok := sc.numbers(2)
+			if !ok {
+				return
+			}
+			p.arcTo(n[0], n[1], n[2], large, sweep, ox+end[0], oy+end[1])
+		case 'Z', 'z':
+			p.close()
+		default:
+			return
+		}
+		// the control point of the next smooth curve is the current point
+		cx, cy = p.x, p.y
+	}
+	p.close()
+}
+
+// logoFill is the fill of the elements of a logo, inherited from their parents
+type logoFill struct {
+	m affine
+	// color is the fill color, none is true if the elements are not filled
+	color   color.NRGBA
+	none    bool
+	opacity float64
+}
+
+// parseFillColor returns the color of a fill attribute, false if the fill is inherited, e.g.
+// for the gradients and the unknown colors
+func parseFillColor(fill string) (c color.NRGBA, none bool, ok bool) {
+	fill = strings.ToLower(strings.TrimSpace(fill))
+	switch {
+	case fill == "none" || fill == "transparent":
+		return c, true, true
+	case fill == "black":
+		return color.NRGBA{0, 0, 0, 0xff}, false, true
+	case fill == "white":
+		return color.NRGBA{0xff, 0xff, 0xff, 0xff}, false, true
+	case strings.HasPrefix(fill, "#") && hexColorMatch.MatchString(fill):
+		return parseHexColor(fill), false, true
+	}
+	return c, false, false
+}
+
+// svgLength returns the number of a length attribute in pixels, def if it is not set or not
+// a number
+func svgLength(value string, def float64) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "px"), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// logo draws the logo in the box x, y, width, height of the badge, the logo is scaled to fit
+// in the box and centered as an image of the SVG badges. The shapes of the logo are filled,
+// their strokes and gradients are only drawn in the SVG badges.
+func (c *pngCanvas) logo(logo *badgeLogo, style string, x, y, width, height float32) {
+	dec := xml.NewDecoder(strings.NewReader(logo.svg(style)))
+	// fills are the fills of the parents of the current element, skip the depth of the
+	// element whose content is not drawn
+	var fills []logoFill
+	depth, skip := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			errLogger.Println(err)
+			return
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if skip > 0 {
+				continue
+			}
+			attrs := map[string]string{}
+			for _, attr := range t.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+
+			var f logoFill
+			if len(fills) == 0 {
+				f = c.logoViewport(attrs, x, y, width, height)
+			} else {
+				f = fills[len(fills)-1]
+				f.m = f.m.mul(parseTransform(attrs["transform"]))
+			}
+			if col, none, ok := parseFillColor(attrs["fill"]); ok {
+				f.color, f.none = col, none
+			}
+			for _, name := range []string{"opacity", "fill-opacity"} {
+				if v, ok := attrs[name]; ok {
+					f.opacity *= math.Max(0, math.Min(1, svgLength(v, 1)))
+				}
+			}
+			fills = append(fills, f)
+
+			switch {
+			case logoShapes[t.Name.Local]:
+				c.logoShape(t.Name.Local, attrs, f)
+			case !logoContainers[t.Name.Local]:
+				skip = depth
+			}
+		case xml.EndElement:
+			if skip == 0 {
+				fills = fills[:len(fills)-1]
+			}
+			if skip == depth {
+				skip = 0
+				fills = fills[:len(fills)-1]
+			}
+			depth--
+		}
+	}
+}
+
+// logoViewport returns the fill of the root element of a logo, its viewBox is scaled to fit
+// in the box and centered
+func (c *pngCanvas) logoViewport(attrs map[string]string, x, y, width, height float32) logoFill {
+	vx, vy := 0.0, 0.0
+	vw, vh := svgLength(attrs["width"], svgImageWidth), svgLength(attrs["height"], svgImageHeight)
+	sc := &svgScanner{s: attrs["viewBox"]}
+	if box, ok := sc.numbers(4); ok && box[2] > 0 && box[3] > 0 {
+		vx, vy, vw, vh = box[0], box[1], box[2], box[3]
+	}
+	k := math.Min(float64(width)/vw, float64(height)/vh)
+	ox := float64(x) + (float64(width)-vw*k)/2 - vx*k
+	oy := float64(y) + (float64(height)-vh*k)/2 - vy*k
+	s := float64(c.scale)
+	return logoFill{
+		m:       affine{k * s, 0, 0, k * s, ox * s, oy * s},
+		color:   color.NRGBA{0, 0, 0, 0xff},
+		opacity: 1,
+	}
+}
+
+// logoShape fills the shape element of a logo
+func (c *pngCanvas) logoShape(name string, attrs map[string]string, f logoFill) {
+	if f.none || f.opacity == 0 {
+		return
+	}
+	length := func(name string) float64 {
+		return svgLength(attrs[name], 0)
+	}
+	p := &logoPath{z: vector.NewRasterizer(c.img.Bounds().Dx(), c.img.Bounds().Dy()), m: f.m}
+	switch name {
+	case "path":
+		p.path(attrs["d"])
+	case "circle":
+		if r := length("r"); r > 0 {
+			p.ellipse(length("cx"), length("cy"), r, r)
+		}
+	case "ellipse":
+		if rx, ry := length("rx"), length("ry"); rx > 0 && ry > 0 {
+			p.ellipse(length("cx"), length("cy"), rx, ry)
+		}
+	case "rect":
+		rx, ry := length("rx"), length("ry")
+		if _, ok := attrs["ry"]; !ok {
+			ry = rx
+		}
+		if _, ok := attrs["rx"]; !ok {
+			rx = ry
+		}
+		if w, h := length("width"), length("height"); w > 0 && h > 0 {
+			p.rect(length("x"), length("y"), w, h, rx, ry)
+		}
+	case "polygon", "polyline":
+		sc := &svgScanner{s: attrs["points"]}
+		for i := 0; ; i++ {
+			n, ok := sc.numbers(2)
+			if !ok {
+				break
+			}
+			if i == 0 {
+				p.moveTo(n[0], n[1])
+			} else {
+				p.lineTo(n[0], n[1])
+			}
+		}
+		p.close()
+	}
+
+	col := f.color
+	col.A = uint8(float64(col.A)*f.opacity + .5)
+	p.z.Draw(c.img, c.img.Bounds(), image.NewUniform(col), image.Point{})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseTransform(t *testing.T) {
+	for transform, expected := range map[string]affine{
+		"":                            {1, 0, 0, 1, 0, 0},
+		"translate(2 3)":              {1, 0, 0, 1, 2, 3},
+		"translate(2,3) scale(2)":     {2, 0, 0, 2, 2, 3},
+		"matrix(1 2 3 4 5 6)":         {1, 2, 3, 4, 5, 6},
+		"scale(2, 3) translate(1, 1)": {2, 0, 0, 3, 2, 3},
+		"rotate(90) unknown(1) skew(": {0, 1, -1, 0, 0, 0},
+	} {
+		m := parseTransform(transform)
+		for i := range m {
+			if d := m[i] - expected[i]; d > 1e-9 || d < -1e-9 {
+				t.Log(transform, "expected", expected, "got", m)
+				t.Fail()
+				break
+			}
+		}
+	}
+}
+
+func TestCanvasLogo(t *testing.T) {
+	red, none := color.NRGBA{0xff, 0, 0, 0xff}, color.NRGBA{}
+	for svg, pixels := range map[string]map[[2]int]color.NRGBA{
+		// the viewBox is scaled to the box
+		`<svg viewBox="0 0 10 10"><rect width="10" height="5" fill="#f00"/></svg>`: {{2, 2}: red, {17, 8}: red, {10, 15}: none},
+		// the fill is inherited, the content of defs and the shapes without fill are not drawn
+		`<svg viewBox="0 0 20 20" fill="red"><g fill="#f00"><defs><rect width="20" height="20"/></defs><circle cx="5" cy="5" r="4"/><rect x="10" width="10" height="10" fill="none"/></g></svg>`: {{5, 5}: red, {15, 5}: none, {5, 15}: none},
+		// the paths are drawn with relative, smooth and arc commands
+		`<svg viewBox="0 0 20 20"><path fill="#f00" d="M0 10a10 10 0 0 1 20 0zm0 2h5v5H0z"/></svg>`:  {{10, 5}: red, {2, 14}: red, {10, 15}: none, {1, 1}: none},
+		`<svg viewBox="0 0 20 20"><path fill="#f00" d="M0 0C0 0 20 0 20 0S20 20 20 20L0 20"/></svg>`: {{10, 10}: red},
+		// the transforms are applied
+		`<svg viewBox="0 0 20 20"><g transform="translate(10 10)"><polygon fill="#f00" points="0,0 10,0 10,10 0,10"/></g></svg>`: {{15, 15}: red, {5, 5}: none},
+	} {
+		c := newPNGCanvas(20, 20, 1)
+		c.logo(&badgeLogo{SVG: svg}, "", 0, 0, 20, 20)
+		for p, expected := range pixels {
+			if got := color.NRGBAModel.Convert(c.img.At(p[0], p[1])).(color.NRGBA); got != expected {
+				t.Log(svg, p, "expected", expected, "got", got)
+				t.Fail()
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	svg := `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 24 24" onload="alert(1)">` +
+		`<script>alert(1)</script><foreignObject><div>hi</div></foreignObject>` +
+		`<g fill="url(#a)" style="fill:red"><path d="M0 0h24v24H0z" onclick="alert(1)" fill="url(https://example.com/x.svg)"/></g>` +
+		`<use xlink:href="https://example.com/x.svg#a"/><image href="https://example.com/x.png"/></svg>`
+	expected := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24"><g fill="url(#a)"><path d="M0 0h24v24H0z"></path></g></svg>`
+	got, err := sanitizeSVG([]byte(svg))
+	if err != nil || got != expected {
+		t.Log("Expected", expected, "got", got, err)
+		t.Fail()
+	}
+
+	for _, svg := range []string{`<html><svg/></html>`, `<svg><path`, `not xml`, `<!DOCTYPE svg [<!ENTITY x "y">]><svg>&x;</svg>`} {
+		if _, err := sanitizeSVG([]byte(svg)); err == nil {
+			t.Log("Expected an error for", svg)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseBadgeLogo(t *testing.T) {
+	q := url.Values{}
+	if logo, err := parseBadgeLogo(q); logo != nil || err != nil {
+		t.Log("Expected no logo, got", logo, err)
+		t.Fail()
+	}
+
+	q.Set("logo", "Gopher")
+	q.Set("logoColor", "00ADD8")
+	q.Set("logoWidth", "20")
+	logo, err := parseBadgeLogo(q)
+	if err != nil || logo.Name != "go" || logo.Color != "#00add8" || logo.Width != 20 {
+		t.Log("Expected the colored go logo, got", logo, err)
+		t.FailNow()
+	}
+	if svg := logo.svg("flat"); !strings.Contains(svg, `<g fill="#00add8">`) {
+		t.Log("Expected the logo color in", svg)
+		t.Fail()
+	}
+	q.Del("logoColor")
+	logo, _ = parseBadgeLogo(q)
+	if svg := logo.svg("social"); !strings.Contains(svg, `<g fill="#333">`) {
+		t.Log("Expected the social logo color in", svg)
+		t.Fail()
+	}
+
+	q = url.Values{}
+	data := base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><circle r="5"/></svg>`))
+	q.Set("logo", "data:image/svg+xml;base64,"+data)
+	logo, err = parseBadgeLogo(q)
+	if err != nil || logo.SVG != `<svg xmlns="http://www.w3.org/2000/svg"><circle r="5"></circle></svg>` || logo.Width != logoWidth {
+		t.Log("Expected the sanitized logo, got", logo, err)
+		t.Fail()
+	}
+
+	for _, values := range []url.Values{
+		{"logo": {"bitbucket"}},
+		{"logo": {"data:image/svg+xml;base64,!!"}},
+		{"logo": {"data:image/png;base64," + data}},
+		{"logo": {"github"}, "logoWidth": {"100"}},
+	} {
+		if _, err := parseBadgeLogo(values); err != ErrInvalidLogo {
+			t.Log("Expected an invalid logo for", values, "got", err)
+			t.Fail()
+		}
+	}
+	if _, err := parseBadgeLogo(url.Values{"logo": {"github"}, "logoColor": {"nope"}}); err != ErrInvalidColor {
+		t.Log("Expected an invalid logo color, got", err)
+		t.Fail()
+	}
+}
+
+func TestBadgeLogo(t *testing.T) {
+	logo := &badgeLogo{Name: "github", Width: logoWidth}
+	plain := &badge{Label: "cover.run", Status: "100%"}
+	plain.layout()
+	b := &badge{Label: "cover.run", Status: "100%"}
+	b.setLogo(logo, "flat")
+	b.layout()
+
+	shift := logoWidth + logoPadding
+	if b.LabelWidth != plain.LabelWidth+shift || b.Width != plain.Width+shift || b.LabelX != plain.LabelX+shift*10 || b.StatusX != plain.StatusX+shift*10 || b.LogoX != badgePadding {
+		t.Log("Expected the label to be moved after the logo, got", b, "without logo", plain)
+		t.Fail()
+	}
+
+	for _, style := range []string{"flat", "flat-square", "plastic", "for-the-badge", "social"} {
+		svg := getLabelBadge("cover.run", "green", style, "100%", logo)
+		if !strings.Contains(svg, `<image x="`) || !strings.Contains(svg, `xlink:href="data:image/svg+xml;base64,`) {
+			t.Log("Expected a logo in the", style, "badge, got", svg)
+			t.Fail()
+		}
+	}
+	if svg := getTrendBadge("cover.run", "green", "flat", "100%", nil, logo); !strings.Contains(svg, "<image") {
+		t.Log("Expected a logo in the trend badge, got", svg)
+		t.Fail()
+	}
+}
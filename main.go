@@ -3,30 +3,25 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/go-redis/cache"
 	"github.com/go-redis/redis"
-	"github.com/gofn/gofn"
-	"github.com/gofn/gofn/provision"
 	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
-	msgpack "gopkg.in/vmihailenco/msgpack.v2"
 )
 
 const (
 	// coverQMax is the maximum number of coverage run to be executed simultaneously
 	coverQMax = 5
-	// coverQName is the Redis channel name where the requests are queued
+	// coverQName is the prefix of the Redis keys in which the requests are queued
 	coverQName = "coverqueue"
 
 	// inProgrsKey is the redis HSet key in which all repo + tags which are currently being run
@@ -35,30 +30,31 @@ const (
 
 	// DefaultTag is the Go version to run the tests with when no version
 	// is specified
-	DefaultTag = "1.10"
-	// cacheExpiry is the duration in which the cache will be expired
+	DefaultTag = latestAlias
+	// cacheExpiry is the duration after which a result is stale, it is still served until the
+	// cover run refreshing it is done
 	cacheExpiry = time.Hour
 	// refreshWindows is the time duration, in which if the cache is about to expire
 	// cover run is started again.
 	refreshWindow = time.Minute * 10
-
-	// Redis Errors
-	redisErrNil      = "redis: nil"
-	redisErrNotFound = "cache: key is missing"
+	// refreshRetry is the time after which a result whose refresh failed is refreshed again
+	refreshRetry = time.Hour
+	// resultRetention is the duration for which the last result of a repository is kept
+	resultRetention = 30 * 24 * time.Hour
+	// latestCount is the number of recent results listed
+	latestCount = 5
 )
 
 var (
 	// errLogger is the log instance with all the required flags set for error logging
 	errLogger = log.New(os.Stderr, "Cover.Run ", log.LstdFlags|log.Lshortfile)
 
-	// qLock is used to push to Redis channel because redis pub-sub in go-redis is
-	// not concurrency safe
-	qLock = sync.Mutex{}
 	// qChan is used to control the number of simultaneos executions
 	qChan = make(chan struct{}, coverQMax)
 
+	// httpClient is the client of the requests to the git hosts, checking that a repository
+	// exists and posting the status of the pull requests
 	httpClient = &http.Client{
-		// img.shields.io response time is very slow
 		Timeout: 30 * time.Second,
 	}
 
@@ -75,50 +71,47 @@ var (
 	ErrCovInPrgrs = errors.New("Test in progress")
 	// ErrNoTest is the error returned when no tests are found in the repository
 	ErrNoTest = errors.New("No tests found")
-
-	redisRing = redis.NewRing(&redis.RingOptions{
-		Addrs: map[string]string{
-			"server1": "redis:6379",
-		},
-	})
-	redisCodec = &cache.Codec{
-		Redis: redisRing,
-
-		Marshal: func(v interface{}) ([]byte, error) {
-			return msgpack.Marshal(v)
-		},
-		Unmarshal: func(b []byte, v interface{}) error {
-			return msgpack.Unmarshal(b, v)
-		},
-	}
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:         "redis:6379",
-		ReadTimeout:  time.Second * 2,
-		DialTimeout:  time.Second * 5,
-		WriteTimeout: time.Second * 5,
-		PoolTimeout:  time.Second * 120,
-	})
-
-	pageTmpl = template.Must(template.ParseFiles("./templates/page.tmpl"))
-
-	// coverageMatch regex is used to match and find the coverage details from stdout
-	coverageMatch = regexp.MustCompile("([coverage\\: ][0-9]+[.]?[0-9]*?[%])")
+	// ErrInvalidRef is the error returned when the git ref requested is not a valid name
+	ErrInvalidRef = errors.New("Invalid branch, tag or commit")
+	// ErrTimeout is the error returned when a cover run takes longer than its timeout
+	ErrTimeout = errors.New("Cover run timed out")
+	// ErrInvalidProfile is the error returned when the coverage profile of a cover run cannot
+	// be parsed, e.g. when the output was cut
+	ErrInvalidProfile = errors.New("Invalid coverage profile")
+
+	// refMatch matches the git branch, tag or commit names which can be measured
+	refMatch = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/-]*$`)
+
+	// store is the storage backend, the memory store is replaced in main with the one
+	// configured
+	store Store = newMemoryStore()
+	// queue is the queue of cover runs, the local queue is replaced in main with the one
+	// matching the configured store
+	queue Queue = newLocalQueue(store)
+
+	pageTmpl   = template.Must(template.ParseFiles("./templates/page.tmpl"))
+	reportTmpl = template.Must(template.ParseFiles("./templates/report.tmpl"))
 )
 
-// langVersionSupported returns true if the given Go version is supported
-func langVersionSupported(version string) bool {
-	switch version {
-	case "golang-1.10",
-		"golang-1.9",
-		"golang-1.8":
-		return true
+// repoRoot returns the root of the repository of an import path, e.g. github.com/user/repo
+// for github.com/user/repo/v2 or github.com/user/repo/sub/pkg
+func repoRoot(repo string) string {
+	parts := strings.SplitN(repo, "/", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
 	}
-	return false
+	return strings.Join(parts, "/")
+}
+
+// validRef returns true if ref is empty (the default branch) or a valid branch, tag or
+// commit name
+func validRef(ref string) bool {
+	return ref == "" || (refMatch.MatchString(ref) && !strings.Contains(ref, ".."))
 }
 
 // repoExists checks if the given repository exists (works only if HTTP request returns 200)
 func repoExists(repo string) (bool, error) {
-	resp, err := httpClient.Get(fmt.Sprintf("https://%s", repo))
+	resp, err := httpClient.Get(fmt.Sprintf("https://%s", repoRoot(repo)))
 	if err != nil {
 		return false, err
 	}
@@ -133,26 +126,41 @@ func repoExists(repo string) (bool, error) {
 	return true, nil
 }
 
-// run runs the custom script to get the coverage details; using gofn
-func run(langVersion, repo string) (string, string, error) {
+// run runs the custom script to get the coverage details, in containers with the limits of
+// the Go version
+// ref is the branch, tag or commit to measure, the default branch if empty
+func run(langVersion, repo, ref string) (string, string, error) {
+	if !validRef(ref) {
+		return "", "", ErrInvalidRef
+	}
+
 	_, err := repoExists(repo)
 	if err != nil {
 		return "", "", err
 	}
 
-	buildOpts := &provision.BuildOptions{
-		DoNotUsePrefixImageName: true,
-		ImageName:               strings.ToLower(fmt.Sprintf("avelino/cover.run:%s", langVersion)),
-		StdIN:                   fmt.Sprintf("sh /run.sh %s", repo),
+	image := fmt.Sprintf("%s:%s", imageRepo, langVersion)
+	v, ok := versions.Resolve(langVersion)
+	if ok {
+		image = v.Image
+	}
+	limits := versionLimits(v)
+
+	env := make([]string, 0)
+	if config.allowFailures(repo) {
+		env = append(env, "COVER_ALLOW_FAILURES=1")
 	}
 
-	// 5 minutes timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*300)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout(repo))
 	defer cancel()
 
-	StdOut, StdErr, err := gofn.Run(ctx, buildOpts, &provision.ContainerOptions{})
+	StdOut, StdErr, err := runSandboxed(ctx, strings.ToLower(image), limits, env, repo, ref)
+	if err == context.DeadlineExceeded {
+		// the output written before the timeout is kept
+		err = ErrTimeout
+	}
 	if err != nil {
-		errLogger.Println(err, buildOpts)
+		errLogger.Println(err, image, repo, ref)
 	}
 
 	return StdOut, StdErr, err
@@ -160,98 +168,107 @@ func run(langVersion, repo string) (string, string, error) {
 
 // Object struct holds all the details of a repository
 type Object struct {
-	Repo   string
-	Tag    string
+	Repo string
+	Tag  string
+	// Cover is the coverage as shown in the badge if the tests passed, the error otherwise
 	Cover  string
 	Output bool
+	// Status is the state of the cover run
+	Status RunStatus
+	// Percent is the statement weighted coverage of all packages
+	Percent float64
+	// Error is the error of a cover run which did not pass, it is empty if the coverage was
+	// measured despite failing tests
+	Error string
+	// StartedAt and FinishedAt are the start and end times of the cover run
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Ref is the branch, tag or commit requested, empty for the default branch
+	Ref string
+	// Commit is the SHA of the commit measured
+	Commit string
+	// Packages is the coverage breakdown per package
+	Packages []PackageCoverage
+	// Files is the coverage breakdown per source file
+	Files []FileCoverage
+	// Mode is either modeModule or modeGOPATH, depending on how the repository was measured
+	Mode string
+	// Failures are the packages whose tests failed
+	Failures []FailedPackage
+	// Tests are the test results, only known with the Go versions supporting go test -json
+	Tests *TestSummary
+	// Outdated is true if the ref was pushed since the result was measured, the result is
+	// served until it is measured again
+	Outdated bool `json:",omitempty"`
+	// LastAttemptAt is the end time of the last cover run which failed to refresh the result,
+	// it is not refreshed again before refreshRetry
+	LastAttemptAt time.Time `json:",omitempty"`
 }
 
-// repoFullName generates a name by combining the Go tag
-func repoFullName(repo, tag string) string {
+// repoFullName generates a name by combining the Go tag, and the git ref if any
+// e.g. github.com/user/repo:golang-1.11 or github.com/user/repo@v1.2.0:golang-1.11
+func repoFullName(repo, tag, ref string) string {
+	if ref != "" {
+		return fmt.Sprintf("%s@%s:%s", repo, ref, tag)
+	}
 	return fmt.Sprintf("%s:%s", repo, tag)
 }
 
-// repoTagFromFullName returns the repo name and Go tag, given the generated full name
-func repoTagFromFullName(msg string) (string, string) {
-	parts := strings.Split(msg, ":")
-	if len(parts) == 2 {
-		return parts[0], parts[1]
-	}
-	return "", ""
+// newRedisClient returns the Redis client used for the queue
+func newRedisClient(addr string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         addr,
+		ReadTimeout:  time.Second * 2,
+		DialTimeout:  time.Second * 5,
+		WriteTimeout: time.Second * 5,
+		PoolTimeout:  time.Second * 120,
+	})
 }
 
-// addToQ pushes a new cover run request to the Redis channel
-func addToQ(repo, tag string) error {
-	qLock.Lock()
-	err := redisClient.Publish(coverQName, repoFullName(repo, tag)).Err()
-	qLock.Unlock()
+// addToQ pushes a new cover run request to the queue, nothing is done if the same
+// repo + tag + ref is already queued
+func addToQ(repo, tag, ref string) error {
+	_, err := queue.Push(newJob(repo, tag, ref))
 	return err
 }
 
-// repoCoverStatus returns true if a repository + tag cover run is in progress
-func repoCoverStatus(repo, tag string) (bool, error) {
-	// Check if cover run is already in progress for the given repo and tag
-	err := redisRing.HGet(inProgrsKey, repoFullName(repo, tag)).Err()
-	if err == nil {
-		return true, nil
-	}
-	if err.Error() != redisErrNil {
+// repoCoverStatus returns true if a repository + tag + ref cover run is in progress
+func repoCoverStatus(repo, tag, ref string) (bool, error) {
+	// Check if cover run is already in progress for the given repo, tag and ref
+	inprogress, err := store.InProgress(repoFullName(repo, tag, ref))
+	if err != nil {
 		errLogger.Println(err)
 	}
-
-	return false, err
+	return inprogress, err
 }
 
-// setInProgress sets the repo + tag as in progress by adding to inPrgorsKey
-func setInProgress(repo, tag string) error {
-	err := redisRing.HSet(inProgrsKey, repoFullName(repo, tag), "y").Err()
+// setInProgress sets the repo + tag + ref as in progress
+func setInProgress(repo, tag, ref string) error {
+	err := store.SetInProgress(repoFullName(repo, tag, ref))
 	if err != nil {
 		errLogger.Println(err)
 	}
 	return err
 }
 
-// unsetInProgress unsets the repo + tag from inprogress status
-func unsetInProgress(repo, tag string) error {
-	err := redisRing.HDel(inProgrsKey, repoFullName(repo, tag)).Err()
+// unsetInProgress unsets the repo + tag + ref from inprogress status
+func unsetInProgress(repo, tag, ref string) error {
+	err := store.UnsetInProgress(repoFullName(repo, tag, ref))
 	if err != nil {
 		errLogger.Println(err)
 	}
 	return err
 }
 
-// computeCoverage returns a string with the final computed coverage value
-// Coverage percentages are read from the string output of go coverage
-func computeCoverage(stdOut string) string {
-	nn := coverageMatch.FindAllString(stdOut, -1)
-	total := float64(0.00)
-	count := float64(0.00)
-	for _, n := range nn {
-		n = strings.TrimSpace(n)
-		n = strings.Trim(n, "%")
-		f, err := strconv.ParseFloat(n, 64)
-		if err != nil {
-			continue
-		}
-		total += f
-		count++
-	}
-
-	// to prevent divide by 0
-	if count < 1.0 {
-		count = 1.00
-	}
-	// rounding to 2
-	return fmt.Sprintf("%.2f%%", (total / count))
-}
-
-// cover evaluates the coverage of a repository
+// runCover evaluates the coverage of a repository, it returns the result and the coverage
+// report, which is nil if there is no output
 // - Before starting evaluation, it sets the repo's status as in progress
 // - Removes the inprogress status of a repo after it's done
-func cover(repo, langVersion string) error {
-	setInProgress(repo, langVersion)
+func runCover(repo, langVersion, ref string) (*Object, *Report, error) {
+	setInProgress(repo, langVersion, ref)
+	started := time.Now()
 
-	stdOut, stdErr, err := run(langVersion, repo)
+	stdOut, stdErr, err := run(langVersion, repo, ref)
 	if err != nil {
 		errLogger.Println(err)
 		if len(stdErr) == 0 {
@@ -259,79 +276,235 @@ func cover(repo, langVersion string) error {
 		}
 	}
 
-	unsetInProgress(repo, langVersion)
+	unsetInProgress(repo, langVersion, ref)
 
 	obj := &Object{
-		Repo:   repo,
-		Tag:    langVersion,
-		Cover:  stdErr,
-		Output: false,
+		Repo:       repo,
+		Tag:        langVersion,
+		Ref:        ref,
+		Cover:      stdErr,
+		Output:     false,
+		Status:     StatusPassed,
+		Error:      stdErr,
+		StartedAt:  started,
+		FinishedAt: time.Now(),
+	}
+	if err != nil {
+		obj.Status = errorStatus(err)
 	}
 
-	if stdOut != "" {
-		obj.Cover = computeCoverage(stdOut)
-		obj.Output = true
+	if err == ErrTimeout {
+		out := parseRunOutput(stdOut)
+		obj.Cover = ErrTimeout.Error()
+		obj.Packages = parseTestCoverage(out.Test)
+		obj.Tests = testSummary(out.Events)
+		return obj, nil, err
 	}
 
-	rerr := redisCodec.Set(&cache.Item{
-		Key:        repoFullName(repo, langVersion),
-		Object:     obj,
-		Expiration: time.Hour,
-	})
-	if rerr != nil {
-		errLogger.Println(rerr)
+	if err == ErrTestsFailed {
+		out := parseRunOutput(stdOut)
+		obj.Failures = failedPackages(out.Events, out.Test)
+		obj.Tests = testSummary(out.Events)
+		return obj, nil, err
+	}
+
+	// the output of a failed run is only the output of go test
+	var report *Report
+	if err == nil && stdOut != "" {
+		report, err = measureCover(obj, stdOut)
+		if err != nil {
+			obj.Cover, obj.Error, obj.Status = err.Error(), err.Error(), errorStatus(err)
+			return obj, nil, err
+		}
 	}
-	<-qChan
 
 	if err == nil && obj.Cover == "" {
-		return ErrNoTest
+		obj.Status, obj.Error = StatusNoTests, ErrNoTest.Error()
+		return obj, report, ErrNoTest
+	}
+
+	return obj, report, err
+}
+
+// measureCover sets the coverage of the output of a cover run which passed on obj, and
+// returns the coverage report. It returns ErrInvalidProfile if the coverage profile cannot
+// be parsed, rather than a coverage of 0%.
+func measureCover(obj *Object, stdOut string) (*Report, error) {
+	out := parseRunOutput(stdOut)
+	profiles, err := parseProfiles(strings.NewReader(out.Profile))
+	if err != nil {
+		errLogger.Println(obj.Repo, err)
+		return nil, ErrInvalidProfile
+	}
+	// the tests of some packages failed, only the coverage of the others is kept
+	if out.Meta["tests"] == "failed" {
+		obj.Status = StatusTestsFailed
+		obj.Failures = failedPackages(out.Events, out.Test)
+		profiles = passedProfiles(profiles, obj.Failures)
+	}
+	obj.Cover, obj.Packages = computeCoverage(profiles)
+	_, _, obj.Percent = totalCoverage(obj.Packages)
+	obj.Files = fileCoverage(profiles)
+	obj.Tests = testSummary(out.Events)
+	obj.Mode = out.Meta["mode"]
+	obj.Commit = out.Meta["commit"]
+	obj.Output = true
+	obj.Error = ""
+
+	return &Report{
+		Repo:       obj.Repo,
+		Tag:        obj.Tag,
+		Ref:        obj.Ref,
+		Profiles:   profiles,
+		Sources:    out.Sources,
+		Commit:     obj.Commit,
+		FinishedAt: obj.FinishedAt,
+	}, nil
+}
+
+// saveCover saves the result and the coverage report of a cover run
+func saveCover(obj *Object, report *Report) {
+	name := repoFullName(obj.Repo, obj.Tag, obj.Ref)
+	if report != nil {
+		err := store.Set(reportKey(obj.Repo, obj.Tag, obj.Ref), report, resultRetention)
+		if err != nil {
+			errLogger.Println(err)
+		}
+	}
+
+	err := store.Set(name, obj, resultRetention)
+	if err != nil {
+		errLogger.Println(err)
+	}
+
+	if obj.Output {
+		err = store.PushLatest(name)
+		if err != nil {
+			errLogger.Println(err)
+		}
+
+		err = store.AddHistory(name, newHistoryEntry(obj, time.Now()))
+		if err != nil {
+			errLogger.Println(err)
+		}
 	}
+}
 
+// refreshCover queues a cover run to refresh the result of a repository, unless it is not due
+// or already running. The last result is served until the run is done.
+func refreshCover(obj *Object) {
+	if !obj.refreshDue(time.Now()) {
+		return
+	}
+	inprogress, _ := repoCoverStatus(obj.Repo, obj.Tag, obj.Ref)
+	if inprogress {
+		return
+	}
+	err := addToQ(obj.Repo, obj.Tag, obj.Ref)
+	if err != nil {
+		errLogger.Println(err)
+	}
+}
+
+// replacesCover returns true if the result of a cover run which ended with err replaces the
+// saved result of the repository. A run which timed out, or whose fetch or tests failed,
+// keeps the last measured coverage, which is served as stale until a run passes.
+func replacesCover(repo, tag, ref string, err error) bool {
+	switch err {
+	case nil, ErrRepoNotFound, ErrNoTest, ErrImgUnSupported, ErrInvalidRef:
+		return true
+	}
+	last := &Object{}
+	if store.Get(repoFullName(repo, tag, ref), last) != nil {
+		return true
+	}
+	last.migrate()
+	return !last.Output
+}
+
+// refreshFailed saves the end time of the failed cover run obj on the result it did not
+// replace, so the result is not refreshed again before refreshRetry
+func refreshFailed(obj *Object) {
+	name := repoFullName(obj.Repo, obj.Tag, obj.Ref)
+	last := &Object{}
+	err := store.Get(name, last)
+	if err != nil {
+		errLogger.Println(err)
+		return
+	}
+	last.LastAttemptAt = obj.FinishedAt
+	err = store.Set(name, last, resultRetention)
+	if err != nil {
+		errLogger.Println(err)
+	}
+}
+
+// cover evaluates the coverage of a repository and saves the result
+func cover(repo, langVersion, ref string) error {
+	obj, report, err := runCover(repo, langVersion, ref)
+	saveCover(obj, report)
 	return err
 }
 
-// repoCover returns code coverage details for the given repository and Go version
+// repoReport returns the coverage report of the given repository, Go version and git ref
+func repoReport(repo, imageTag, ref string) (*Report, error) {
+	report := &Report{}
+	err := store.Get(reportKey(repo, imageTag, ref), report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// repoCover returns code coverage details for the given repository, Go version and git ref
 // - It checks if the coverage details is available in cache or not
 // - It checks if the cover run is in progress or not
 // - It checks if cover can be run simultaneously, if not request is pushed to Q
-func repoCover(repo, imageTag string) (*Object, error) {
+func repoCover(repo, imageTag, ref string) (*Object, error) {
 	obj := &Object{
 		Repo: repo,
 		Tag:  imageTag,
+		Ref:  ref,
+	}
+
+	if !validRef(ref) {
+		obj.Cover, obj.Error, obj.Status = ErrInvalidRef.Error(), ErrInvalidRef.Error(), StatusUnsupported
+		return obj, ErrInvalidRef
 	}
 
-	if !langVersionSupported(imageTag) {
-		obj.Cover = ErrImgUnSupported.Error()
+	version, ok := versions.Resolve(imageTag)
+	if !ok {
+		obj.Cover, obj.Error, obj.Status = ErrImgUnSupported.Error(), ErrImgUnSupported.Error(), StatusUnsupported
 		return obj, ErrImgUnSupported
 	}
+	// the canonical name is used, so all the aliases of a version share the results
+	imageTag = version.Name
+	obj.Tag = imageTag
 
-	err := redisCodec.Get(repoFullName(repo, imageTag), &obj)
+	err := store.Get(repoFullName(repo, imageTag, ref), &obj)
 	if err == nil {
+		obj.migrate()
+		refreshCover(obj)
 		return obj, nil
 	}
 
-	if err.Error() != redisErrNotFound {
+	if err != ErrNotFound {
 		errLogger.Println(err)
 	}
 
-	inprogress, err := repoCoverStatus(repo, imageTag)
-	if err != nil {
-		if err.Error() != redisErrNil {
-			errLogger.Println(err)
-		}
-	}
+	inprogress, _ := repoCoverStatus(repo, imageTag, ref)
 	if inprogress {
-		obj.Cover = ErrCovInPrgrs.Error()
+		obj.Cover, obj.Status = ErrCovInPrgrs.Error(), StatusRunning
 		return obj, ErrCovInPrgrs
 	}
 
-	err = addToQ(repo, imageTag)
+	err = addToQ(repo, imageTag, ref)
 	if err != nil {
 		errLogger.Println(err)
 		return obj, ErrUnknown
 	}
 
-	obj.Cover = ErrQueued.Error()
+	obj.Cover, obj.Status = ErrQueued.Error(), StatusQueued
 
 	return obj, ErrQueued
 }
@@ -344,18 +517,15 @@ type Repository struct {
 
 func repoLatest() ([]*Repository, error) {
 	repos := make([]*Repository, 0)
-	keys, _, err := redisRing.Scan(0, "*", 10).Result()
+	keys, err := store.Latest(latestCount)
 	if err != nil {
 		errLogger.Println(err)
 		return repos, err
 	}
 
-	var obj Object
 	for _, key := range keys {
-		if len(repos) == 5 {
-			return repos, nil
-		}
-		if err := redisCodec.Get(key, &obj); err == nil {
+		obj := Object{}
+		if err := store.Get(key, &obj); err == nil {
 			if obj.Output {
 				repos = append(repos, &Repository{obj.Repo, obj.Tag, obj.Cover})
 			}
@@ -364,35 +534,131 @@ func repoLatest() ([]*Repository, error) {
 	return repos, nil
 }
 
-// subscribe subscribes to the Redis channel
-func subscribe(qname string) {
-	pubsub := redisClient.Subscribe(qname)
-	defer pubsub.Close()
+// retryable returns true if the cover run failed for a reason which might go away when
+// run again, e.g. Docker or network errors. Failing tests, or a repository which does not
+// exist, will fail the same way again.
+func retryable(err error) bool {
+	switch err {
+	case nil,
+		ErrRepoNotFound,
+		ErrNoTest,
+		ErrImgUnSupported,
+		ErrInvalidRef,
+		ErrTimeout,
+		ErrFetchFailed,
+		ErrTestsFailed:
+		return false
+	}
+	return true
+}
 
-	for {
-		msg, err := pubsub.ReceiveMessage()
+// process runs a job popped from the queue. A job which failed with a retryable error is
+// nacked, its result is saved only if it has no attempts left.
+func process(q Queue, job *Job) {
+	defer func() {
+		<-qChan
+	}()
+
+	obj, report, err := runCover(job.Repo, job.Tag, job.Ref)
+	if retryable(err) && job.Attempts < queueMaxAttempts {
+		errLogger.Println("retrying", job.ID, "attempt", job.Attempts, err)
+		err = q.Nack(job, err)
 		if err != nil {
 			errLogger.Println(err)
 		}
-		repo, tag := repoTagFromFullName(msg.Payload)
+		return
+	}
+
+	if replacesCover(job.Repo, job.Tag, job.Ref, err) {
+		saveCover(obj, report)
+	} else {
+		refreshFailed(obj)
+	}
+
+	if retryable(err) {
+		errLogger.Println("giving up", job.ID, "after", job.Attempts, "attempts", err)
+		err = q.Nack(job, err)
+	} else {
+		err = q.Ack(job)
+	}
+	if err != nil {
+		errLogger.Println(err)
+	}
+	// the job is done, so a head of a pull request measured again is queued
+	reportPullRequests(job.Repo)
+}
+
+// work pops the jobs from the queue and runs them, at most coverQMax at a time.
+// A slot is taken before popping, so jobs wait in the queue until they can run.
+func work(q Queue) {
+	for {
 		qChan <- struct{}{}
-		go cover(repo, tag)
+		job, err := q.Pop()
+		if err != nil {
+			errLogger.Println(err)
+		}
+
+		if job == nil {
+			<-qChan
+			time.Sleep(queuePoll)
+			continue
+		}
+
+		go process(q, job)
 	}
 }
 
 func main() {
+	storeName := flag.String("store", storeRedis, "storage backend, one of redis, memory or disk")
+	redisAddr := flag.String("redis", "redis:6379", "address of the Redis server")
+	dbPath := flag.String("db", "cover.db", "database file of the disk store")
+	configPath := flag.String("config", "", "JSON configuration file")
+	flag.Parse()
+
+	var err error
+	config, err = loadConfig(*configPath)
+	if err != nil {
+		errLogger.Fatalln(err)
+	}
+	loadVersions(config)
+
+	store, err = newStore(*storeName, *redisAddr, *dbPath)
+	if err != nil {
+		errLogger.Fatalln(err)
+	}
+	if *storeName == storeRedis {
+		queue = newRedisQueue(newRedisClient(*redisAddr))
+	} else {
+		queue = newLocalQueue(store)
+	}
+
 	r := mux.NewRouter()
 	r.HandleFunc("/", Handler)
 	r.HandleFunc("/go", Handler)
+	r.HandleFunc("/versions.json", HandlerVersions)
 	r.PathPrefix("/assets").Handler(
 		http.StripPrefix("/assets", http.FileServer(http.Dir("./assets/"))),
 	)
 
+	// the routes of a repo sub path go first, they would match the repo routes otherwise
+	r.HandleFunc("/go/{repo:.*}/history.json", HandlerRepoHistory)
+	r.HandleFunc("/go/{repo:.*}/trend.svg", HandlerRepoTrend)
+	r.HandleFunc("/go/{repo:.*}/compare.json", HandlerRepoCompare)
+	r.HandleFunc("/go/{repo:.*}/compare.svg", HandlerRepoCompareSVG)
+	r.HandleFunc("/go/{repo:.*}/tests.svg", HandlerRepoTests)
+	r.HandleFunc("/go/{repo:.*}/matrix.json", HandlerRepoMatrix)
+	r.HandleFunc("/go/{repo:.*}/matrix.svg", HandlerRepoMatrixSVG)
+	r.HandleFunc("/go/{repo:.*}/shields.json", HandlerRepoShields)
+
 	r.HandleFunc("/go/{repo:.*}.json", HandlerRepoJSON)
 	r.HandleFunc("/go/{repo:.*}.svg", HandlerRepoSVG)
+	r.HandleFunc("/go/{repo:.*}.png", HandlerRepoPNG)
+	r.HandleFunc("/go/{repo:.*}.html", HandlerRepoHTML)
 	r.HandleFunc("/badge", HandlerBadge)
+	r.HandleFunc("/badge.png", HandlerBadgePNG)
+	r.HandleFunc("/hooks/{provider}", HandlerHook).Methods(http.MethodPost)
 
-	go subscribe(coverQName)
+	go work(queue)
 
 	n := negroni.Classic()
 	n.UseHandler(r)
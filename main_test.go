@@ -2,16 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func TestImageSupported(t *testing.T) {
-	tt := []string{"1.10", "1.9", "1.8"}
+	tt := []string{"1.11", "1.10", "1.9", "1.8"}
 	for _, tag := range tt {
 		if !langVersionSupported("golang-" + tag) {
 			t.Log(tag, " should be suported")
@@ -25,17 +27,35 @@ func TestImageSupported(t *testing.T) {
 	}
 }
 
-func TestGetBadge(t *testing.T) {
-	expected := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="104" height="20"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient><clipPath id="a"><rect width="104" height="20" rx="3" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0h61v20H0z"/><path fill="#d6604a" d="M61 0h53v20H61z"/><path fill="url(#b)" d="M0 0h114v20H0z"/></g><g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="315" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="510">coverage</text><text x="315" y="140" transform="scale(.1)" textLength="510">coverage</text><text x="815" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="">100%</text><text x="815" y="140" transform="scale(.1)" textLength="">100%</text></g> </svg>`
-	str := getBadge("red", "flat", "100%")
-	if str != expected {
-		t.Log("Expected svg badge, got", str)
+func TestRef(t *testing.T) {
+	valid := []string{"master", "v1.0.0", "feature/new-api", "0123abc", "release-1.x"}
+	for _, ref := range valid {
+		if !validRef(ref) {
+			t.Log("Expected valid ref", ref)
+			t.Fail()
+		}
+	}
+
+	invalid := []string{"-f", "../etc", "a b", "a;b", "a..b", "$(id)"}
+	for _, ref := range invalid {
+		if validRef(ref) {
+			t.Log("Expected invalid ref", ref)
+			t.Fail()
+		}
+	}
+
+	if got := repoFullName("github.com/user/repo", "golang-1.10", ""); got != "github.com/user/repo:golang-1.10" {
+		t.Log("Unexpected name", got)
+		t.Fail()
+	}
+	if got := repoFullName("github.com/user/repo", "golang-1.10", "v1.0.0"); got != "github.com/user/repo@v1.0.0:golang-1.10" {
+		t.Log("Unexpected name with ref", got)
 		t.Fail()
 	}
 }
 
 func TestRun(t *testing.T) {
-	_, stderr, err := run("1.10", "github.com/avelino/cover.run")
+	_, stderr, err := run("1.10", "github.com/avelino/cover.run", "")
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -47,13 +67,13 @@ func TestRun(t *testing.T) {
 		t.Fail()
 	}
 
-	_, _, err = run("1.0", "github.com/avelino/cover.run")
+	_, _, err = run("1.0", "github.com/avelino/cover.run", "")
 	if err.Error() != "missing remote repository e.g. 'github.com/user/repo'" {
 		t.Log(err)
 		t.Fail()
 	}
 
-	_, _, err = run("1.10", "github.com/avelino/nonexistent")
+	_, _, err = run("1.10", "github.com/avelino/nonexistent", "")
 	if err != ErrRepoNotFound {
 		t.Log("Expected", ErrRepoNotFound, "got", err)
 		t.Fail()
@@ -61,41 +81,116 @@ func TestRun(t *testing.T) {
 }
 
 func TestRepoCover(t *testing.T) {
-	_, err := repoCover("github.com/avelino/cover.run", "1.10")
+	_, err := repoCover("github.com/avelino/cover.run", "1.10", "")
 	if err != nil && err != ErrCovInPrgrs && err != ErrQueued {
 		t.Log(err)
 		t.Fail()
 	}
 
-	_, err = repoCover("github.com/avelino/cover.run", "1.0.1")
+	_, err = repoCover("github.com/avelino/cover.run", "1.0.1", "")
 	if err != ErrImgUnSupported {
 		t.Log("Expected error ", ErrImgUnSupported, "got", err)
 		t.Fail()
 	}
 
-	_, err = repoCover("github.com/avelino/nonexistent", "1.10")
+	_, err = repoCover("github.com/avelino/nonexistent", "1.10", "")
 	if err != ErrRepoNotFound && err != ErrCovInPrgrs && err != ErrQueued {
 		t.Log("Expected", ErrRepoNotFound, "got", err)
 		t.Fail()
 	}
 }
+
+func TestMeasureCover(t *testing.T) {
+	obj := &Object{Repo: "github.com/user/repo", Tag: "golang-1.10"}
+	report, err := measureCover(obj, outputMarker+sectionProfile+"\n"+testProfile+outputMarker+sectionMeta+"\ncommit=0123abc\n")
+	if err != nil || report == nil || !obj.Output || obj.Percent == 0 || obj.Commit != "0123abc" {
+		t.Log("Expected the coverage to be measured, got", obj, err)
+		t.Fail()
+	}
+
+	// a malformed profile is an error, not a coverage of 0%
+	obj = &Object{Repo: "github.com/user/repo", Tag: "golang-1.10"}
+	report, err = measureCover(obj, outputMarker+sectionProfile+"\nmode: set\ngithub.com/user/repo/small.go:3.10 1\n")
+	if err != ErrInvalidProfile || report != nil || obj.Output || obj.Cover != "" {
+		t.Log("Expected", ErrInvalidProfile, "got", obj, err)
+		t.Fail()
+	}
+}
+
+func TestRefreshFailed(t *testing.T) {
+	oldStore, oldQueue := store, queue
+	defer func() {
+		store, queue = oldStore, oldQueue
+	}()
+	store = newMemoryStore()
+	lq := newLocalQueue(store)
+	queue = lq
+
+	now := time.Now()
+	name := repoFullName("github.com/user/broken", "golang-1.10", "")
+	saveCover(&Object{Repo: "github.com/user/broken", Tag: "golang-1.10", Cover: "80.00%", Output: true, Status: StatusPassed, FinishedAt: now.Add(-2 * time.Hour)}, nil)
+
+	// a refresh whose tests failed keeps the last result, which is not refreshed again
+	refreshFailed(&Object{Repo: "github.com/user/broken", Tag: "golang-1.10", Status: StatusTestsFailed, FinishedAt: now})
+	obj, err := repoCover("github.com/user/broken", "1.10", "")
+	if _, queued := lq.state.Jobs[name]; queued || err != nil || obj.Cover != "80.00%" || !obj.stale(now) {
+		t.Log("Expected the stale result not to be refreshed again, got", obj, err, queued)
+		t.Fail()
+	}
+
+	// it is refreshed again after refreshRetry
+	obj.LastAttemptAt = now.Add(-refreshRetry - time.Minute)
+	store.Set(name, obj, resultRetention)
+	repoCover("github.com/user/broken", "1.10", "")
+	if _, queued := lq.state.Jobs[name]; !queued {
+		t.Log("Expected the result to be refreshed after", refreshRetry)
+		t.Fail()
+	}
+}
+
+func TestReplacesCover(t *testing.T) {
+	oldStore := store
+	defer func() {
+		store = oldStore
+	}()
+	store = newMemoryStore()
+	saveCover(&Object{Repo: "github.com/user/passed", Tag: "golang-1.10", Cover: "80.00%", Output: true, Status: StatusPassed}, nil)
+	saveCover(&Object{Repo: "github.com/user/failed", Tag: "golang-1.10", Cover: ErrTestsFailed.Error(), Status: StatusTestsFailed}, nil)
+
+	// a failed refresh keeps the last measured coverage
+	for _, err := range []error{ErrTimeout, ErrTestsFailed, ErrFetchFailed, ErrUnknown} {
+		if replacesCover("github.com/user/passed", "golang-1.10", "", err) {
+			t.Log("Expected a refresh failing with", err, "to keep the last result")
+			t.Fail()
+		}
+		if !replacesCover("github.com/user/failed", "golang-1.10", "", err) || !replacesCover("github.com/user/new", "golang-1.10", "", err) {
+			t.Log("Expected a run failing with", err, "to be saved without a measured result")
+			t.Fail()
+		}
+	}
+
+	for _, err := range []error{nil, ErrRepoNotFound, ErrNoTest} {
+		if !replacesCover("github.com/user/passed", "golang-1.10", "", err) {
+			t.Log("Expected a run ending with", err, "to replace the last result")
+			t.Fail()
+		}
+	}
+}
+
 func TestCover(t *testing.T) {
-	qChan <- struct{}{}
-	err := cover("github.com/avelino/cover.run", "1.10")
+	err := cover("github.com/avelino/cover.run", "1.10", "")
 	if err != nil {
 		t.Log(err)
 		t.Fail()
 	}
 
-	qChan <- struct{}{}
-	err = cover("github.com/avelino/cover.run", "1.0.1")
+	err = cover("github.com/avelino/cover.run", "1.0.1", "")
 	if err == nil {
 		t.Log("Expected error ", "got", err)
 		t.Fail()
 	}
 
-	qChan <- struct{}{}
-	err = cover("github.com/avelino/nonexistent", "1.10")
+	err = cover("github.com/avelino/nonexistent", "1.10", "")
 	if err != ErrRepoNotFound {
 		t.Log("Expected", ErrRepoNotFound, "got", err)
 		t.Fail()
@@ -105,6 +200,7 @@ func TestCover(t *testing.T) {
 func setup() (*mux.Router, *httptest.ResponseRecorder) {
 	r := mux.NewRouter()
 	r.HandleFunc("/", Handler)
+	r.HandleFunc("/versions.json", HandlerVersions)
 	r.HandleFunc("/go/{repo:.*}.json", HandlerRepoJSON)
 	r.HandleFunc("/go/{repo:.*}.svg", HandlerRepoSVG)
 	return r, httptest.NewRecorder()
@@ -159,3 +255,104 @@ func TestHandlerRepoSVG(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestHandlerRepoHTML(t *testing.T) {
+	oldStore := store
+	defer func() {
+		store = oldStore
+	}()
+	store = newMemoryStore()
+
+	profiles, _ := parseProfiles(strings.NewReader("mode: set\ngithub.com/user/repo/a.go:3.14,4.10 1 1\n"))
+	finished := time.Now().Add(-time.Minute)
+	obj := &Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Cover: "100.00%", Output: true, Status: StatusPassed, Commit: "0123abc", FinishedAt: finished}
+	saveCover(obj, &Report{Repo: obj.Repo, Tag: obj.Tag, Profiles: profiles, Sources: map[string]string{"github.com/user/repo/a.go": "package repo\n"}, Commit: "0123abc", FinishedAt: finished})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/go/{repo:.*}", HandlerRepoHTML)
+	page := func() string {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo?tag=1.10", nil))
+		return rec.Body.String()
+	}
+
+	if body := page(); !strings.Contains(body, "a.go") {
+		t.Log("Expected the report of the result, got", body)
+		t.Fail()
+	}
+
+	// a later run whose tests failed has no report, the previous one is not shown
+	saveCover(&Object{Repo: obj.Repo, Tag: obj.Tag, Cover: ErrTestsFailed.Error(), Status: StatusTestsFailed, FinishedAt: time.Now()}, nil)
+	if body := page(); strings.Contains(body, "a.go") {
+		t.Log("Expected the report of the previous run not to be shown, got", body)
+		t.Fail()
+	}
+}
+
+func TestNewRepoResponse(t *testing.T) {
+	obj := &Object{
+		Repo:   "github.com/user/repo",
+		Tag:    "golang-1.10",
+		Cover:  "18.18%",
+		Output: true,
+		Packages: []PackageCoverage{
+			{Name: "github.com/user/repo", Statements: 1, Covered: 1, Percent: 100},
+			{Name: "github.com/user/repo/big", Statements: 10, Covered: 1, Percent: 10},
+		},
+		Files: []FileCoverage{
+			{Name: "github.com/user/repo/small.go", Statements: 1, Covered: 1, Percent: 100},
+		},
+	}
+
+	resp := newRepoResponse(obj, "")
+	if resp.SchemaVersion != apiSchemaVersion || resp.Cover != obj.Cover || resp.Repo != obj.Repo {
+		t.Log("Expected the legacy fields to be set, got", resp)
+		t.Fail()
+	}
+
+	if resp.Statements != 11 || resp.Covered != 2 || resp.Percent != 18.18 {
+		t.Log("Unexpected totals", resp.Statements, resp.Covered, resp.Percent)
+		t.Fail()
+	}
+
+	if resp.Files != nil {
+		t.Log("Expected no files without detail, got", resp.Files)
+		t.Fail()
+	}
+
+	resp = newRepoResponse(obj, detailFiles)
+	if len(resp.Files) != 1 {
+		t.Log("Expected 1 file, got", resp.Files)
+		t.Fail()
+	}
+
+	resp = newRepoResponse(&Object{Repo: "github.com/user/repo"}, "")
+	if resp.Packages == nil {
+		t.Log("Expected packages to be an empty list")
+		t.Fail()
+	}
+
+	old := &Object{Repo: "github.com/user/repo", Cover: "42.50%", Output: true}
+	old.migrate()
+	if resp = newRepoResponse(old, ""); resp.Percent != 42.5 {
+		t.Log("Expected the percentage of a result without packages, got", resp.Percent)
+		t.Fail()
+	}
+}
+
+func TestHandlerVersions(t *testing.T) {
+	router, respRec := setup()
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/versions.json", bytes.NewBuffer(nil))
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	router.ServeHTTP(respRec, req)
+
+	list := []*GoVersion{}
+	err = json.NewDecoder(respRec.Body).Decode(&list)
+	if err != nil || len(list) != len(defaultVersions) || list[0].Name != "golang-1.11" {
+		t.Log("Unexpected versions", list, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// MatrixEntry is the result of a repository with one Go version
+type MatrixEntry struct {
+	// Version is the Go version, e.g. 1.11
+	Version string
+	// Tag is the canonical name of the version, e.g. golang-1.11
+	Tag     string
+	Status  RunStatus
+	Cover   string
+	Percent float64
+}
+
+// MatrixResponse is the JSON response with the results of a repository with all the
+// supported Go versions
+type MatrixResponse struct {
+	Repo string
+	Ref  string
+	// Versions are the results by Go version, the oldest first
+	Versions []MatrixEntry
+}
+
+// repoMatrix returns the results of the repo + ref with all the supported Go versions, the
+// versions not measured yet are queued
+func repoMatrix(repo, ref string) *MatrixResponse {
+	list := versions.List()
+	resp := &MatrixResponse{
+		Repo:     repo,
+		Ref:      ref,
+		Versions: make([]MatrixEntry, 0, len(list)),
+	}
+
+	// the versions are listed newest first
+	for i := len(list) - 1; i >= 0; i-- {
+		v := list[i]
+		obj, err := repoCover(repo, v.Name, ref)
+		if err != nil && err != ErrQueued && err != ErrCovInPrgrs {
+			errLogger.Println(err)
+		}
+		resp.Versions = append(resp.Versions, MatrixEntry{
+			Version: v.Version,
+			Tag:     v.Name,
+			Status:  obj.Status,
+			Cover:   obj.Cover,
+			Percent: obj.Percent,
+		})
+	}
+	return resp
+}
+
+// matrixSegment returns the badge segment of a Go version, e.g. "1.11 ✓"
+func matrixSegment(entry MatrixEntry) badgeSegment {
+	switch entry.Status {
+	case StatusPassed:
+		return badgeSegment{Text: entry.Version + " ✓", Color: "green"}
+	case StatusQueued, StatusRunning:
+		return badgeSegment{Text: entry.Version + " …", Color: "lightgrey"}
+	case StatusTimeout:
+		return badgeSegment{Text: entry.Version + " ⌛", Color: "orange"}
+	}
+	return badgeSegment{Text: entry.Version + " ✗", Color: "red"}
+}
+
+// matrixParams returns the repo and ref of a matrix request, and false if the ref is invalid
+func matrixParams(r *http.Request) (string, string, bool) {
+	repo := strings.TrimSpace(mux.Vars(r)["repo"])
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+	return repo, ref, validRef(ref)
+}
+
+// HandlerRepoMatrix returns the results of a repository with all the supported Go versions
+// as JSON, the versions not measured yet are queued
+func HandlerRepoMatrix(w http.ResponseWriter, r *http.Request) {
+	repo, ref, ok := matrixParams(r)
+	if !ok {
+		http.Error(w, ErrInvalidRef.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(repoMatrix(repo, ref))
+}
+
+// HandlerRepoMatrixSVG returns the SVG badge with the state of a repository with every
+// supported Go version, e.g. "1.9 ✓ 1.10 ✓ 1.11 ✗"
+func HandlerRepoMatrixSVG(w http.ResponseWriter, r *http.Request) {
+	repo, ref, ok := matrixParams(r)
+	if !ok {
+		http.Error(w, ErrInvalidRef.Error(), http.StatusBadRequest)
+		return
+	}
+
+	badgeStyle := parseBadgeStyle(r.URL.Query().Get("style"))
+
+	matrix := repoMatrix(repo, ref)
+	segments := make([]badgeSegment, 0, len(matrix.Versions))
+	for _, entry := range matrix.Versions {
+		segments = append(segments, matrixSegment(entry))
+	}
+	svg := getSegmentsBadge(badgeStyle, segments)
+
+	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
+	w.Header().Set("pragma", "no-cache")
+	w.Header().Set("expires", "-1")
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	w.Write([]byte(svg))
+}
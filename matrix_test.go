@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandlerRepoMatrix(t *testing.T) {
+	oldStore, oldQueue, oldVersions := store, queue, versions
+	defer func() {
+		store, queue, versions = oldStore, oldQueue, oldVersions
+	}()
+	store = newMemoryStore()
+	lq := newLocalQueue(store)
+	queue = lq
+	versions = newVersionRegistry(versionConfigs([]string{"1.11", "1.10", "1.9"}))
+
+	saveCover(&Object{Repo: "github.com/user/repo", Tag: "golang-1.9", Cover: "50.00%", Output: true, Status: StatusPassed, Percent: 50}, nil)
+	saveCover(&Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Cover: "Tests failed", Status: StatusTestsFailed}, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/go/{repo:.*}/matrix.json", HandlerRepoMatrix)
+	r.HandleFunc("/go/{repo:.*}/matrix.svg", HandlerRepoMatrixSVG)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/matrix.json", nil))
+	resp := &MatrixResponse{}
+	err := json.NewDecoder(rec.Body).Decode(resp)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	expected := []MatrixEntry{
+		{Version: "1.9", Tag: "golang-1.9", Status: StatusPassed, Cover: "50.00%", Percent: 50},
+		{Version: "1.10", Tag: "golang-1.10", Status: StatusTestsFailed, Cover: "Tests failed"},
+		{Version: "1.11", Tag: "golang-1.11", Status: StatusQueued, Cover: ErrQueued.Error()},
+	}
+	if len(resp.Versions) != len(expected) {
+		t.Log("Expected", expected, "got", resp.Versions)
+		t.FailNow()
+	}
+	for i := range expected {
+		if resp.Versions[i] != expected[i] {
+			t.Log("Expected", expected[i], "got", resp.Versions[i])
+			t.Fail()
+		}
+	}
+	if _, ok := lq.state.Jobs[repoFullName("github.com/user/repo", "golang-1.11", "")]; !ok {
+		t.Log("Expected the missing version to be queued, got", lq.state.Jobs)
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/matrix.svg?style=flat", nil))
+	svg := rec.Body.String()
+	if rec.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(svg, "clipPath") {
+		t.Log("Expected a flat SVG badge, got", rec.Header(), svg)
+		t.FailNow()
+	}
+	for _, text := range []string{">1.9 ✓<", ">1.10 ✗<", ">1.11 …<"} {
+		if !strings.Contains(svg, text) {
+			t.Log("Expected", text, "in", svg)
+			t.Fail()
+		}
+	}
+	if strings.Count(svg, `fill-opacity=".4"`) != 2 {
+		t.Log("Expected a separator between the segments, got", svg)
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/matrix.json?ref=-bad", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Log("Expected", http.StatusBadRequest, "got", rec.Code)
+		t.Fail()
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// pullsPrefix is the prefix of the store keys of the pull requests waiting for their
+	// coverage to be reported, by repo
+	pullsPrefix = "pulls:"
+	// pullCommentsPrefix is the prefix of the store keys of the ids of the pull request
+	// comments, by repo and number
+	pullCommentsPrefix = "pullcomment:"
+
+	// commentLinesMax is the maximum number of newly uncovered lines listed in a comment
+	commentLinesMax = 20
+)
+
+var (
+	// pullsMu guards the pending pull requests and reportingPulls
+	pullsMu sync.Mutex
+	// reportingPulls are the repos whose pull requests are being reported, true if a cover run
+	// of the repo was done meanwhile and they must be reported again
+	reportingPulls = map[string]bool{}
+)
+
+// PullRequest is a pull request (or merge request) whose coverage is reported to its host
+type PullRequest struct {
+	// Provider is the git host, github or gitlab
+	Provider string
+	Repo     string
+	// Path is the path of the project on its host given by the webhook, e.g. group/sub/project
+	// for a project of a GitLab subgroup
+	Path   string
+	Number int
+	// Base is the ref of the target branch, empty for the default branch
+	Base string
+	// Head is the ref of the pull request, which can be fetched from the repo even if the
+	// branch is in a fork
+	Head string
+	// Commit is the SHA of the head commit, the status is set on it
+	Commit string
+	Tag    string
+}
+
+// pullHeadRef returns the ref of a pull request head in the repo of its host
+func pullHeadRef(provider string, number int) string {
+	if provider == hookGitLab {
+		return fmt.Sprintf("merge-requests/%d/head", number)
+	}
+	return fmt.Sprintf("pull/%d/head", number)
+}
+
+// path returns the path of the project of the pull request on its host, the one of the repo for
+// the pull requests queued without it
+func (pr *PullRequest) path() string {
+	if pr.Path != "" {
+		return pr.Path
+	}
+	return repoPath(pr.Repo)
+}
+
+// pullsKey returns the store key of the pending pull requests of a repo
+func pullsKey(repo string) string {
+	return pullsPrefix + repo
+}
+
+// pullCommentKey returns the store key of the id of the comment of a pull request
+func pullCommentKey(repo string, number int) string {
+	return fmt.Sprintf("%s%s#%d", pullCommentsPrefix, repo, number)
+}
+
+// pendingPulls returns the pull requests of the repo waiting for their coverage. The caller
+// must hold pullsMu.
+func pendingPulls(repo string) []*PullRequest {
+	pulls := make([]*PullRequest, 0)
+	err := store.Get(pullsKey(repo), &pulls)
+	if err != nil && err != ErrNotFound {
+		errLogger.Println(err)
+	}
+	return pulls
+}
+
+// savePendingPulls saves the pull requests of the repo waiting for their coverage. The caller
+// must hold pullsMu.
+func savePendingPulls(repo string, pulls []*PullRequest) error {
+	if len(pulls) == 0 {
+		return store.Delete(pullsKey(repo))
+	}
+	return store.Set(pullsKey(repo), pulls, 0)
+}
+
+// pullTargetURL returns the URL of the coverage comparison of the pull request
+func pullTargetURL(pr *PullRequest) string {
+	query := url.Values{}
+	query.Set("tag", pr.Tag)
+	query.Set("head", pr.Head)
+	if pr.Base != "" {
+		query.Set("base", pr.Base)
+	}
+	return fmt.Sprintf("%s/go/%s/compare.json?%s", config.baseURL(), pr.Repo, query.Encode())
+}
+
+// queuePullRequest queues the cover runs of the base and head of the pull request, its
+// coverage is reported once both are done. A previous head of the same pull request is
+// replaced.
+func queuePullRequest(pr *PullRequest) error {
+	inst := config.installation(pr.Repo)
+	if inst == nil {
+		return nil
+	}
+
+	tag := inst.Tag
+	if tag == "" {
+		tag = DefaultTag
+	}
+	v, ok := versions.Resolve(tag)
+	if !ok {
+		return ErrImgUnSupported
+	}
+	pr.Tag = v.Name
+
+	// the head ref is the same for all the commits of the pull request
+	for _, key := range []string{repoFullName(pr.Repo, pr.Tag, pr.Head), reportKey(pr.Repo, pr.Tag, pr.Head)} {
+		err := store.Delete(key)
+		if err != nil {
+			errLogger.Println(err)
+		}
+	}
+
+	pullsMu.Lock()
+	pulls := pendingPulls(pr.Repo)
+	for i, p := range pulls {
+		if p.Number == pr.Number {
+			pulls = append(pulls[:i], pulls[i+1:]...)
+			break
+		}
+	}
+	pulls = append(pulls, pr)
+	err := savePendingPulls(pr.Repo, pulls)
+	pullsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range []string{pr.Base, pr.Head} {
+		err = addToQ(pr.Repo, pr.Tag, ref)
+		if err != nil {
+			return err
+		}
+	}
+
+	sp, err := newStatusProvider(inst)
+	if err != nil {
+		return err
+	}
+	return sp.SetStatus(pr, statusPending, "Measuring coverage", pullTargetURL(pr))
+}
+
+// pullStatus returns the state and description of the commit status of a comparison
+func pullStatus(resp *CompareResponse, threshold float64) (string, string) {
+	if resp.Status == compareError {
+		return statusError, "Coverage could not be measured"
+	}
+
+	description := fmt.Sprintf("Coverage %.2f%% (%+.2f%%)", resp.Head.Percent, resp.Delta)
+	if -resp.Delta > threshold {
+		return statusFailure, fmt.Sprintf("%s, dropped more than %.2f%%", description, threshold)
+	}
+	return statusSuccess, description
+}
+
+// pullComment returns the markdown comment with the coverage summary of a comparison
+func pullComment(pr *PullRequest, resp *CompareResponse) string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "### [cover.run](%s) coverage report\n\n", pullTargetURL(pr))
+
+	if resp.Status == compareError {
+		fmt.Fprintf(buf, "Coverage could not be measured with %s: base `%s`, head `%s`\n", resp.Tag, resp.Base.Cover, resp.Head.Cover)
+		return buf.String()
+	}
+
+	fmt.Fprintf(buf, "Coverage **%+.2f%%**, from %.2f%% to %.2f%% with %s.\n", resp.Delta, resp.Base.Percent, resp.Head.Percent, resp.Tag)
+
+	changed := make([]CoverageDelta, 0)
+	for _, pkg := range resp.Packages {
+		if pkg.Delta != 0 {
+			changed = append(changed, pkg)
+		}
+	}
+	if len(changed) > 0 {
+		buf.WriteString("\n| Package | Base | Head | Delta |\n| --- | ---: | ---: | ---: |\n")
+		for _, pkg := range changed {
+			fmt.Fprintf(buf, "| %s | %.2f%% | %.2f%% | %+.2f%% |\n", pkg.Name, pkg.BasePercent, pkg.HeadPercent, pkg.Delta)
+		}
+	}
+
+	if len(resp.Uncovered) > 0 {
+		fmt.Fprintf(buf, "\n%d newly uncovered lines:\n\n", len(resp.Uncovered))
+		for i, line := range resp.Uncovered {
+			if i == commentLinesMax {
+				fmt.Fprintf(buf, "- and %d more\n", len(resp.Uncovered)-commentLinesMax)
+				break
+			}
+			fmt.Fprintf(buf, "- %s:%d `%s`\n", line.File, line.Line, strings.TrimSpace(line.Text))
+		}
+	}
+	return buf.String()
+}
+
+// reportPullRequest posts the status and comment of the pull request to its host, it
+// returns false if the coverage of the pull request is not known yet
+func reportPullRequest(pr *PullRequest) (bool, error) {
+	resp := compareRefs(pr.Repo, pr.Tag, pr.Base, pr.Head)
+	if resp.Status == compareQueued || resp.Status == compareTesting {
+		return false, nil
+	}
+
+	// a run of a previous commit of the pull request, the current one is measured again
+	if resp.Status == compareDone && resp.Head.Commit != "" && resp.Head.Commit != pr.Commit {
+		outdateCover(pr.Repo, pr.Head)
+		return false, addToQ(pr.Repo, pr.Tag, pr.Head)
+	}
+
+	inst := config.installation(pr.Repo)
+	if inst == nil {
+		return true, nil
+	}
+	sp, err := newStatusProvider(inst)
+	if err != nil {
+		return true, err
+	}
+
+	state, description := pullStatus(resp, inst.Threshold)
+	err = sp.SetStatus(pr, state, description, pullTargetURL(pr))
+	if err != nil {
+		return true, err
+	}
+
+	if inst.Comment {
+		err = commentPullRequest(sp, pr, pullComment(pr, resp))
+	}
+	return true, err
+}
+
+// commentPullRequest posts the comment of the pull request, the comment of a previous head is
+// updated so that the pull request has a single cover.run comment
+func commentPullRequest(sp StatusProvider, pr *PullRequest, body string) error {
+	key := pullCommentKey(pr.Repo, pr.Number)
+	var id int64
+	err := store.Get(key, &id)
+	if err != nil && err != ErrNotFound {
+		errLogger.Println(err)
+	}
+
+	id, err = sp.Comment(pr, id, body)
+	if err != nil {
+		return err
+	}
+	return store.Set(key, id, resultRetention)
+}
+
+// reportPullRequests reports the pending pull requests of the repo whose coverage is known,
+// it is called after every cover run of the repo. The statuses and comments are posted
+// without holding pullsMu, a cover run done meanwhile has the running report go again.
+func reportPullRequests(repo string) {
+	pullsMu.Lock()
+	if _, ok := reportingPulls[repo]; ok {
+		reportingPulls[repo] = true
+		pullsMu.Unlock()
+		return
+	}
+
+	for again := true; again; again = reportingPulls[repo] {
+		reportingPulls[repo] = false
+		pulls := pendingPulls(repo)
+		if len(pulls) == 0 {
+			break
+		}
+		pullsMu.Unlock()
+
+		reported := make([]*PullRequest, 0, len(pulls))
+		for _, pr := range pulls {
+			done, err := reportPullRequest(pr)
+			if err != nil {
+				errLogger.Println(err)
+			}
+			if done {
+				reported = append(reported, pr)
+			}
+		}
+
+		// the pull requests queued while reporting are kept, even the new heads of the
+		// reported ones
+		pullsMu.Lock()
+		remaining := make([]*PullRequest, 0)
+		for _, pr := range pendingPulls(repo) {
+			if !containsPull(reported, pr) {
+				remaining = append(remaining, pr)
+			}
+		}
+		err := savePendingPulls(repo, remaining)
+		if err != nil {
+			errLogger.Println(err)
+		}
+	}
+	delete(reportingPulls, repo)
+	pullsMu.Unlock()
+}
+
+// containsPull returns true if the pull request with the same head commit is in pulls
+func containsPull(pulls []*PullRequest, pr *PullRequest) bool {
+	for _, p := range pulls {
+		if p.Number == pr.Number && p.Commit == pr.Commit {
+			return true
+		}
+	}
+	return false
+}
+
+// pullPayload holds the fields of a GitHub pull_request or a GitLab merge request event
+type pullPayload struct {
+	// GitHub
+	Action      string `json:"action"`
+	PullRequest struct {
+		Number int `json:"number"`
+		Head   struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName      string `json:"full_name"`
+		HTMLURL       string `json:"html_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+
+	// GitLab
+	ObjectAttributes struct {
+		IID          int    `json:"iid"`
+		Action       string `json:"action"`
+		TargetBranch string `json:"target_branch"`
+		LastCommit   struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+		DefaultBranch     string `json:"default_branch"`
+	} `json:"project"`
+}
+
+// pullActions are the actions of the pull request events which change its head
+var pullActions = map[string]bool{
+	// GitHub
+	"opened":      true,
+	"reopened":    true,
+	"synchronize": true,
+	// GitLab
+	"open":   true,
+	"reopen": true,
+	"update": true,
+}
+
+// parsePullPayload returns the pull request of the webhook payload, and whether its head
+// changed and must be measured
+func parsePullPayload(provider string, body []byte) (*PullRequest, bool, error) {
+	payload := &pullPayload{}
+	err := json.Unmarshal(body, payload)
+	if err != nil {
+		return nil, false, ErrHookPayload
+	}
+
+	pr := &PullRequest{Provider: provider}
+	repoURL, defaultBranch, action := "", "", ""
+	switch provider {
+	case hookGitHub:
+		pr.Number = payload.PullRequest.Number
+		pr.Commit = payload.PullRequest.Head.SHA
+		pr.Base = payload.PullRequest.Base.Ref
+		pr.Path = payload.Repository.FullName
+		repoURL, defaultBranch = payload.Repository.HTMLURL, payload.Repository.DefaultBranch
+		action = payload.Action
+	case hookGitLab:
+		pr.Number = payload.ObjectAttributes.IID
+		pr.Commit = payload.ObjectAttributes.LastCommit.ID
+		pr.Base = payload.ObjectAttributes.TargetBranch
+		pr.Path = payload.Project.PathWithNamespace
+		repoURL, defaultBranch = payload.Project.WebURL, payload.Project.DefaultBranch
+		action = payload.ObjectAttributes.Action
+	default:
+		return nil, false, ErrHookProvider
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" || pr.Number < 1 || pr.Commit == "" {
+		return nil, false, ErrHookPayload
+	}
+	pr.Repo = u.Host + strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	pr.Head = pullHeadRef(provider, pr.Number)
+	if pr.Base == defaultBranch {
+		pr.Base = ""
+	}
+	if !validRef(pr.Base) {
+		return nil, false, ErrInvalidRef
+	}
+
+	return pr, pullActions[action], nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeRequest is a request received by the fake git host
+type fakeRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   map[string]string
+}
+
+// fakeGitHost is a local server standing in for the GitHub and GitLab APIs, it records the
+// requests it receives. The created comments have the id 42, and the comment 404 does not
+// exist.
+type fakeGitHost struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []fakeRequest
+	// onRequest is called on every request if set
+	onRequest func()
+}
+
+func newFakeGitHost() *fakeGitHost {
+	fh := &fakeGitHost{}
+	fh.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := fakeRequest{Method: r.Method, Path: r.URL.EscapedPath(), Header: r.Header, Body: map[string]string{}}
+		json.NewDecoder(r.Body).Decode(&req.Body)
+		if fh.onRequest != nil {
+			fh.onRequest()
+		}
+
+		fh.mu.Lock()
+		fh.requests = append(fh.requests, req)
+		fh.mu.Unlock()
+
+		if strings.HasSuffix(req.Path, "/404") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":42}`))
+	}))
+	return fh
+}
+
+// take returns the requests received since the last call
+func (fh *fakeGitHost) take() []fakeRequest {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	requests := fh.requests
+	fh.requests = nil
+	return requests
+}
+
+func TestStatusProviders(t *testing.T) {
+	fh := newFakeGitHost()
+	defer fh.Close()
+
+	// the path of the webhook is the one of the nested group
+	pr := &PullRequest{Repo: "gitlab.com/group/sub/repo", Path: "group/sub/repo", Number: 3, Commit: "0123abc"}
+	tt := map[string][]string{
+		hookGitHub: {
+			"POST /repos/group/sub/repo/statuses/0123abc", "POST /repos/group/sub/repo/issues/3/comments",
+			"PATCH /repos/group/sub/repo/issues/comments/42", "Authorization", "token t0k3n",
+		},
+		hookGitLab: {
+			"POST /projects/group%2Fsub%2Frepo/statuses/0123abc", "POST /projects/group%2Fsub%2Frepo/merge_requests/3/notes",
+			"PUT /projects/group%2Fsub%2Frepo/merge_requests/3/notes/42", "Private-Token", "t0k3n",
+		},
+	}
+	for provider, expected := range tt {
+		sp, err := newStatusProvider(&InstallationConfig{Provider: provider, APIURL: fh.URL, Token: "t0k3n"})
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+
+		err = sp.SetStatus(pr, statusFailure, "Coverage dropped", "https://cover.run")
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+		// the comment is created, then updated
+		id, err := sp.Comment(pr, 0, "Coverage report")
+		if err != nil || id != 42 {
+			t.Log(provider, "expected the comment 42, got", id, err)
+			t.Fail()
+		}
+		id, err = sp.Comment(pr, id, "Coverage report updated")
+		if err != nil || id != 42 {
+			t.Log(provider, "expected the comment 42 to be updated, got", id, err)
+			t.Fail()
+		}
+
+		requests := fh.take()
+		if len(requests) != 3 || requests[0].Method+" "+requests[0].Path != expected[0] ||
+			requests[1].Method+" "+requests[1].Path != expected[1] || requests[2].Method+" "+requests[2].Path != expected[2] {
+			t.Log(provider, "unexpected requests", requests)
+			t.FailNow()
+		}
+		if requests[0].Header.Get(expected[3]) != expected[4] {
+			t.Log(provider, "expected the token in", expected[3], "got", requests[0].Header)
+			t.Fail()
+		}
+		if requests[0].Body["description"] != "Coverage dropped" || requests[1].Body["body"] != "Coverage report" ||
+			requests[2].Body["body"] != "Coverage report updated" {
+			t.Log(provider, "unexpected bodies", requests[0].Body, requests[1].Body, requests[2].Body)
+			t.Fail()
+		}
+
+		// a deleted comment is created again
+		id, err = sp.Comment(pr, 404, "Coverage report")
+		if requests = fh.take(); err != nil || id != 42 || len(requests) != 2 || requests[1].Method+" "+requests[1].Path != expected[1] {
+			t.Log(provider, "expected the comment to be created again, got", id, err, requests)
+			t.Fail()
+		}
+	}
+
+	_, err := newStatusProvider(&InstallationConfig{Provider: "bitbucket"})
+	if err == nil {
+		t.Log("Expected an error for an unknown provider")
+		t.Fail()
+	}
+}
+
+func TestParsePullPayload(t *testing.T) {
+	payload := `{"object_attributes":{"iid":5,"action":"update","target_branch":"develop","last_commit":{"id":"abc1234"}},"project":{"path_with_namespace":"group/sub/project","web_url":"https://gitlab.com/group/sub/project","default_branch":"master"}}`
+	pr, changed, err := parsePullPayload(hookGitLab, []byte(payload))
+	if err != nil || !changed {
+		t.Log("Expected a changed merge request, got", changed, err)
+		t.FailNow()
+	}
+	expected := &PullRequest{Provider: hookGitLab, Repo: "gitlab.com/group/sub/project", Path: "group/sub/project", Number: 5, Base: "develop", Head: "merge-requests/5/head", Commit: "abc1234"}
+	if *pr != *expected {
+		t.Log("Expected", expected, "got", pr)
+		t.Fail()
+	}
+
+	// the pull requests queued without the path use the one of the repo
+	if path := (&PullRequest{Repo: "gitlab.com/group/sub/project"}).path(); path != "group/sub/project" {
+		t.Log("Expected the full path of the project, got", path)
+		t.Fail()
+	}
+}
+
+func TestPullRequestStatus(t *testing.T) {
+	fh := newFakeGitHost()
+	defer fh.Close()
+
+	oldStore, oldQueue, oldConfig := store, queue, config
+	defer func() {
+		store, queue, config = oldStore, oldQueue, oldConfig
+	}()
+	store = newMemoryStore()
+	lq := newLocalQueue(store)
+	queue = lq
+	config = &Config{
+		Repos: map[string]RepoConfig{"github.com/user/repo": {Secret: "s3cr3t"}},
+		Installations: []InstallationConfig{{
+			Provider:  hookGitHub,
+			APIURL:    fh.URL,
+			Token:     "t0k3n",
+			Accounts:  []string{"github.com/user"},
+			Tag:       "1.10",
+			Comment:   true,
+			Threshold: 1,
+		}},
+	}
+
+	payload := `{"action":"opened","number":7,"pull_request":{"number":7,"head":{"ref":"feature","sha":"def4567"},"base":{"ref":"master"}},"repository":{"html_url":"https://github.com/user/repo","default_branch":"master"}}`
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(payload))
+
+	r := mux.NewRouter()
+	r.HandleFunc("/hooks/{provider}", HandlerHook).Methods(http.MethodPost)
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost/hooks/github", bytes.NewBufferString(payload))
+	req.Header.Set("X-GitHub-Event", "pull_request")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Log("Expected", http.StatusAccepted, "got", rec.Code, rec.Body.String())
+		t.FailNow()
+	}
+
+	for _, ref := range []string{"", "pull/7/head"} {
+		if _, ok := lq.state.Jobs[repoFullName("github.com/user/repo", "golang-1.10", ref)]; !ok {
+			t.Log("Expected a job for ref", ref, "got", lq.state.Jobs)
+			t.Fail()
+		}
+	}
+
+	requests := fh.take()
+	if len(requests) != 1 || requests[0].Path != "/repos/user/repo/statuses/def4567" || requests[0].Body["state"] != statusPending {
+		t.Log("Expected a pending status, got", requests)
+		t.FailNow()
+	}
+
+	// nothing is reported until both refs are measured
+	reportPullRequests("github.com/user/repo")
+	if requests = fh.take(); len(requests) != 0 {
+		t.Log("Expected nothing to be reported, got", requests)
+		t.Fail()
+	}
+
+	baseReport, headReport := testCompareReports(t)
+	for ref, report := range map[string]*Report{"": baseReport, "pull/7/head": headReport} {
+		obj := &Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Ref: ref, Commit: "def4567", Output: true}
+		obj.Cover, obj.Packages = computeCoverage(report.Profiles)
+		obj.Files = fileCoverage(report.Profiles)
+		saveCover(obj, report)
+	}
+
+	// the pull requests are not locked while reporting, one queued meanwhile is kept
+	queued := &PullRequest{Provider: hookGitHub, Repo: "github.com/user/repo", Number: 8, Head: "pull/8/head", Commit: "fed7654", Tag: "golang-1.10"}
+	var once sync.Once
+	fh.onRequest = func() {
+		once.Do(func() {
+			if !pullsMu.TryLock() {
+				t.Log("Expected the pull requests not to be locked while reporting")
+				t.Fail()
+				return
+			}
+			defer pullsMu.Unlock()
+			err := savePendingPulls("github.com/user/repo", append(pendingPulls("github.com/user/repo"), queued))
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+			}
+		})
+	}
+
+	reportPullRequests("github.com/user/repo")
+	requests = fh.take()
+	if len(requests) != 2 {
+		t.Log("Expected a status and a comment, got", requests)
+		t.FailNow()
+	}
+	if requests[0].Body["state"] != statusFailure || !strings.Contains(requests[0].Body["description"], "-41.67%") {
+		t.Log("Expected a failed status, got", requests[0].Body)
+		t.Fail()
+	}
+	if requests[1].Path != "/repos/user/repo/issues/7/comments" || !strings.Contains(requests[1].Body["body"], "**-41.67%**") ||
+		!strings.Contains(requests[1].Body["body"], "2 newly uncovered lines") {
+		t.Log("Unexpected comment", requests[1].Path, requests[1].Body["body"])
+		t.Fail()
+	}
+
+	var id int64
+	if err := store.Get(pullCommentKey("github.com/user/repo", 7), &id); err != nil || id != 42 {
+		t.Log("Expected the id of the comment to be saved, got", id, err)
+		t.Fail()
+	}
+
+	if pulls := pendingPulls("github.com/user/repo"); len(pulls) != 1 || pulls[0].Number != queued.Number {
+		t.Log("Expected only the queued pull request to be pending, got", pulls)
+		t.Fail()
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// queueMaxAttempts is the number of times a job is run before it is moved to the
+	// dead letter list
+	queueMaxAttempts = 3
+	// queueVisibility is the minimum time a popped job is hidden from the other workers, if
+	// it is neither acked nor nacked in this time it is run again, see jobVisibility
+	queueVisibility = time.Minute * 10
+	// queueVisibilityMargin is the time left to save the result of a cover run after its
+	// timeout, before its job is run again
+	queueVisibilityMargin = time.Minute * 5
+	// queueBackoff is the delay before the first retry of a failed job, it doubles with
+	// every attempt
+	queueBackoff = time.Second * 30
+	// queueBackoffMax is the maximum delay before retrying a failed job
+	queueBackoffMax = time.Minute * 10
+	// queuePoll is the time a worker waits before checking an empty queue again
+	queuePoll = time.Second
+	// queueDeadMax is the maximum number of jobs kept in the dead letter list
+	queueDeadMax = 100
+
+	// localQueueKey is the store key in which the state of the local queue is saved
+	localQueueKey = "cover-queue"
+)
+
+// jobVisibility returns the time a popped job is hidden from the other workers. It is longer
+// than the longest cover run timeout, so a running job is never popped again.
+func jobVisibility() time.Duration {
+	v := config.maxTimeout() + queueVisibilityMargin
+	if v < queueVisibility {
+		return queueVisibility
+	}
+	return v
+}
+
+// Job is a cover run request in the queue
+type Job struct {
+	// ID identifies the job, the same repo + tag + ref is never queued twice
+	ID         string
+	Repo       string
+	Tag        string
+	Ref        string
+	Attempts   int
+	LastError  string
+	EnqueuedAt time.Time
+	// Rerun is true if the job was pushed again while it was running, e.g. by a webhook, it
+	// is queued again once it is done
+	Rerun bool `json:",omitempty"`
+}
+
+// newJob returns a new job to run cover for the repo, tag and git ref
+func newJob(repo, tag, ref string) *Job {
+	return &Job{
+		ID:         repoFullName(repo, tag, ref),
+		Repo:       repo,
+		Tag:        tag,
+		Ref:        ref,
+		EnqueuedAt: time.Now(),
+	}
+}
+
+// Queue is a persistent queue of cover runs
+type Queue interface {
+	// Push adds the job to the queue, it returns false if a job with the same ID is already
+	// queued. A job pushed while it is running is queued again once it is acked.
+	Push(job *Job) (bool, error)
+	// Pop returns the next job ready to run, or nil if there is none. The job is hidden from
+	// the other workers until it is acked, nacked or its visibility timeout expires.
+	Pop() (*Job, error)
+	// Ack removes a finished job from the queue, or queues it again if it was pushed while
+	// running
+	Ack(job *Job) error
+	// Nack marks the job as failed, it is run again after a backoff or moved to the dead
+	// letter list if it has failed too many times
+	Nack(job *Job, reason error) error
+	// DeadLetters returns the jobs which have failed too many times, the most recent first
+	DeadLetters() ([]*Job, error)
+}
+
+// retryBackoff returns the delay before running a job again after the given attempt
+func retryBackoff(attempt int) time.Duration {
+	backoff := queueBackoff
+	for i := 1; i < attempt && backoff < queueBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > queueBackoffMax {
+		backoff = queueBackoffMax
+	}
+	return backoff
+}
+
+// localQueueState is the state of the local queue saved to the store
+type localQueueState struct {
+	Jobs map[string]*Job
+	// Ready is the time (unix nano) after which a queued job can run, by job ID
+	Ready map[string]int64
+	// Running is the visibility deadline (unix nano) of a running job, by job ID
+	Running map[string]int64
+	Dead    []*Job
+}
+
+// localQueue is a Queue saved to a Store, it is durable if the store is
+type localQueue struct {
+	mu    sync.Mutex
+	store Store
+	state *localQueueState
+}
+
+// newLocalQueue returns the queue saved to the store, the existing state is loaded
+func newLocalQueue(s Store) *localQueue {
+	lq := &localQueue{
+		store: s,
+		state: &localQueueState{},
+	}
+
+	err := s.Get(localQueueKey, lq.state)
+	if err != nil && err != ErrNotFound {
+		errLogger.Println(err)
+	}
+
+	if lq.state.Jobs == nil {
+		lq.state.Jobs = make(map[string]*Job)
+	}
+	if lq.state.Ready == nil {
+		lq.state.Ready = make(map[string]int64)
+	}
+	if lq.state.Running == nil {
+		lq.state.Running = make(map[string]int64)
+	}
+
+	return lq
+}
+
+// save saves the state to the store. The caller must hold the lock.
+func (lq *localQueue) save() error {
+	return lq.store.Set(localQueueKey, lq.state, 0)
+}
+
+// Push adds the job to the queue
+func (lq *localQueue) Push(job *Job) (bool, error) {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+
+	if queued, ok := lq.state.Jobs[job.ID]; ok {
+		if _, running := lq.state.Running[job.ID]; !running || queued.Rerun {
+			return false, nil
+		}
+		queued.Rerun = true
+		return true, lq.save()
+	}
+
+	lq.state.Jobs[job.ID] = job
+	lq.state.Ready[job.ID] = time.Now().UnixNano()
+	return true, lq.save()
+}
+
+// Pop returns the job which has been ready to run for the longest time
+func (lq *localQueue) Pop() (*Job, error) {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+
+	now := time.Now().UnixNano()
+
+	// running jobs whose visibility timeout expired are ready to run again
+	for id, deadline := range lq.state.Running {
+		if deadline <= now {
+			delete(lq.state.Running, id)
+			lq.state.Ready[id] = now
+		}
+	}
+
+	ids := make([]string, 0, len(lq.state.Ready))
+	for id, ready := range lq.state.Ready {
+		if ready <= now {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return lq.state.Ready[ids[i]] < lq.state.Ready[ids[j]]
+	})
+
+	id := ids[0]
+	job := lq.state.Jobs[id]
+	job.Attempts++
+	delete(lq.state.Ready, id)
+	lq.state.Running[id] = now + int64(jobVisibility())
+
+	// a copy is returned, so the state is only changed with the lock held
+	j := *job
+	return &j, lq.save()
+}
+
+// Ack removes the job from the queue, or queues it again if it was pushed while running
+func (lq *localQueue) Ack(job *Job) error {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+
+	lq.done(job.ID)
+	return lq.save()
+}
+
+// done removes the job from the queue, a job pushed while running is queued again as a new
+// job. The caller must hold the lock.
+func (lq *localQueue) done(id string) {
+	j, ok := lq.state.Jobs[id]
+	delete(lq.state.Jobs, id)
+	delete(lq.state.Ready, id)
+	delete(lq.state.Running, id)
+	if ok && j.Rerun {
+		lq.state.Jobs[id] = newJob(j.Repo, j.Tag, j.Ref)
+		lq.state.Ready[id] = time.Now().UnixNano()
+	}
+}
+
+// Nack queues the job again after a backoff, or moves it to the dead letter list
+func (lq *localQueue) Nack(job *Job, reason error) error {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+
+	j, ok := lq.state.Jobs[job.ID]
+	if !ok {
+		return nil
+	}
+	delete(lq.state.Running, job.ID)
+
+	if reason != nil {
+		j.LastError = reason.Error()
+	}
+
+	if j.Attempts >= queueMaxAttempts {
+		lq.done(job.ID)
+		dead := *j
+		dead.Rerun = false
+		lq.state.Dead = append([]*Job{&dead}, lq.state.Dead...)
+		if len(lq.state.Dead) > queueDeadMax {
+			lq.state.Dead = lq.state.Dead[:queueDeadMax]
+		}
+		return lq.save()
+	}
+
+	// the retry measures the last push too
+	j.Rerun = false
+	lq.state.Ready[job.ID] = time.Now().Add(retryBackoff(j.Attempts)).UnixNano()
+	return lq.save()
+}
+
+// DeadLetters returns the jobs which have failed too many times
+func (lq *localQueue) DeadLetters() ([]*Job, error) {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+
+	dead := make([]*Job, len(lq.state.Dead))
+	copy(dead, lq.state.Dead)
+	return dead, nil
+}
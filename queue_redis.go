@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// Redis keys of the queue
+	// - coverQName:jobs is a hash of all the queued and running jobs, by job ID
+	// - coverQName:ready is a sorted set of the queued job IDs, scored by the time they can run
+	// - coverQName:running is a sorted set of the running job IDs, scored by their
+	// visibility deadline
+	// - coverQName:rerun is a set of the running job IDs which were pushed again
+	// - coverQName:dead is the list of jobs which have failed too many times
+	redisQJobs    = coverQName + ":jobs"
+	redisQReady   = coverQName + ":ready"
+	redisQRunning = coverQName + ":running"
+	redisQRerun   = coverQName + ":rerun"
+	redisQDead    = coverQName + ":dead"
+)
+
+// redisQPush atomically adds the job to the jobs hash and its ID to ready, unless the job is
+// already queued. A running job is added to rerun instead.
+var redisQPush = redis.NewScript(`
+if redis.call("HSETNX", KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	if redis.call("ZSCORE", KEYS[3], ARGV[1]) then
+		return redis.call("SADD", KEYS[4], ARGV[1])
+	end
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+return 1
+`)
+
+// redisQDone atomically removes the job from the queue, a job in rerun is queued again as the
+// new job
+var redisQDone = redis.NewScript(`
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("ZREM", KEYS[3], ARGV[1])
+if redis.call("SREM", KEYS[4], ARGV[1]) == 1 then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+	return 1
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+return 0
+`)
+
+// redisQPop atomically moves the expired running jobs back to ready, and the first ready job
+// to running. An ID without job in the jobs hash is dropped.
+var redisQPop = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local expired = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", now)
+for _, id in ipairs(expired) do
+	redis.call("ZREM", KEYS[2], id)
+	redis.call("ZADD", KEYS[1], now, id)
+end
+
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", now, "LIMIT", 0, 1)
+if #ids == 0 then
+	return false
+end
+redis.call("ZREM", KEYS[1], ids[1])
+local job = redis.call("HGET", KEYS[3], ids[1])
+if not job then
+	return false
+end
+redis.call("ZADD", KEYS[2], ARGV[2], ids[1])
+return job
+`)
+
+// redisQueue is a Queue saved in Redis
+type redisQueue struct {
+	client *redis.Client
+}
+
+// newRedisQueue returns a queue using the given Redis client
+func newRedisQueue(client *redis.Client) *redisQueue {
+	return &redisQueue{
+		client: client,
+	}
+}
+
+// millis returns the time in milliseconds, used as sorted set score
+func millis(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
+
+// Push adds the job to the queue, the jobs hash is used to find duplicates
+func (rq *redisQueue) Push(job *Job) (bool, error) {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+
+	added, err := redisQPush.Run(
+		rq.client,
+		[]string{redisQJobs, redisQReady, redisQRunning, redisQRerun},
+		job.ID,
+		b,
+		millis(time.Now()),
+	).Result()
+	if err != nil {
+		return false, err
+	}
+	return added == int64(1), nil
+}
+
+// Pop returns the job which has been ready to run for the longest time
+func (rq *redisQueue) Pop() (*Job, error) {
+	now := time.Now()
+	val, err := redisQPop.Run(
+		rq.client,
+		[]string{redisQReady, redisQRunning, redisQJobs},
+		millis(now),
+		millis(now.Add(jobVisibility())),
+	).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := val.(string)
+	if !ok {
+		return nil, nil
+	}
+
+	job := &Job{}
+	err = json.Unmarshal([]byte(raw), job)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Attempts++
+	return job, rq.update(job)
+}
+
+// update saves the job in the jobs hash
+func (rq *redisQueue) update(job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return rq.client.HSet(redisQJobs, job.ID, b).Err()
+}
+
+// Ack removes the job from the queue, or queues it again if it was pushed while running
+func (rq *redisQueue) Ack(job *Job) error {
+	b, err := json.Marshal(newJob(job.Repo, job.Tag, job.Ref))
+	if err != nil {
+		return err
+	}
+
+	return redisQDone.Run(
+		rq.client,
+		[]string{redisQJobs, redisQReady, redisQRunning, redisQRerun},
+		job.ID,
+		b,
+		millis(time.Now()),
+	).Err()
+}
+
+// Nack queues the job again after a backoff, or moves it to the dead letter list
+func (rq *redisQueue) Nack(job *Job, reason error) error {
+	if reason != nil {
+		job.LastError = reason.Error()
+	}
+
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	if job.Attempts >= queueMaxAttempts {
+		err = rq.Ack(job)
+		if err != nil {
+			return err
+		}
+		_, err = rq.client.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.LPush(redisQDead, b)
+			pipe.LTrim(redisQDead, 0, queueDeadMax-1)
+			return nil
+		})
+		return err
+	}
+
+	_, err = rq.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZRem(redisQRunning, job.ID)
+		// the retry measures the last push too
+		pipe.SRem(redisQRerun, job.ID)
+		pipe.HSet(redisQJobs, job.ID, b)
+		pipe.ZAdd(redisQReady, redis.Z{
+			Score:  millis(time.Now().Add(retryBackoff(job.Attempts))),
+			Member: job.ID,
+		})
+		return nil
+	})
+	return err
+}
+
+// DeadLetters returns the jobs in the dead letter list
+func (rq *redisQueue) DeadLetters() ([]*Job, error) {
+	list, err := rq.client.LRange(redisQDead, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(list))
+	for _, raw := range list {
+		job := &Job{}
+		err = json.Unmarshal([]byte(raw), job)
+		if err != nil {
+			errLogger.Println(err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
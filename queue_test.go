@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tt := map[int]time.Duration{
+		1:  queueBackoff,
+		2:  queueBackoff * 2,
+		3:  queueBackoff * 4,
+		20: queueBackoffMax,
+	}
+	for attempt, expected := range tt {
+		if got := retryBackoff(attempt); got != expected {
+			t.Log("Attempt", attempt, "expected", expected, "got", got)
+			t.Fail()
+		}
+	}
+}
+
+func TestLocalQueue(t *testing.T) {
+	q := newLocalQueue(newMemoryStore())
+
+	added, err := q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if err != nil || !added {
+		t.Log("Expected job to be added, got", added, err)
+		t.FailNow()
+	}
+
+	added, _ = q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if added {
+		t.Log("Expected duplicate job not to be added")
+		t.Fail()
+	}
+
+	job, err := q.Pop()
+	if err != nil || job == nil || job.Repo != "github.com/user/repo" || job.Attempts != 1 {
+		t.Log("Unexpected job", job, err)
+		t.FailNow()
+	}
+
+	// a running job is queued again once it is done, only once
+	added, _ = q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if !added {
+		t.Log("Expected running job to be queued again")
+		t.Fail()
+	}
+	added, _ = q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if added {
+		t.Log("Expected running job not to be queued again twice")
+		t.Fail()
+	}
+
+	empty, _ := q.Pop()
+	if empty != nil {
+		t.Log("Expected running job to be hidden, got", empty)
+		t.Fail()
+	}
+
+	// the job is ready again after the visibility timeout
+	q.state.Running[job.ID] = time.Now().Add(-time.Second).UnixNano()
+	job, _ = q.Pop()
+	if job == nil || job.Attempts != 2 {
+		t.Log("Expected job to be run again, got", job)
+		t.FailNow()
+	}
+
+	err = q.Nack(job, errors.New("docker is down"))
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	empty, _ = q.Pop()
+	if empty != nil {
+		t.Log("Expected nacked job to wait for the backoff, got", empty)
+		t.Fail()
+	}
+
+	q.state.Ready[job.ID] = time.Now().UnixNano()
+	job, _ = q.Pop()
+	if job == nil || job.Attempts != queueMaxAttempts || job.LastError != "docker is down" {
+		t.Log("Expected job to be retried, got", job)
+		t.FailNow()
+	}
+
+	q.Nack(job, errors.New("docker is still down"))
+	dead, _ := q.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != job.ID || dead[0].LastError != "docker is still down" {
+		t.Log("Expected job in the dead letter list, got", dead)
+		t.Fail()
+	}
+
+	added, _ = q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if !added {
+		t.Log("Expected dead job to be queued again")
+		t.Fail()
+	}
+
+	job, _ = q.Pop()
+	q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	q.Ack(job)
+	job, _ = q.Pop()
+	if job == nil || job.Attempts != 1 || job.Rerun {
+		t.Log("Expected job pushed while running to be run again, got", job)
+		t.FailNow()
+	}
+
+	q.Ack(job)
+	empty, _ = q.Pop()
+	if empty != nil || len(q.state.Jobs) != 0 {
+		t.Log("Expected empty queue after ack, got", empty, q.state.Jobs)
+		t.Fail()
+	}
+}
+
+func TestRedisQueue(t *testing.T) {
+	client := newRedisClient(testRedisAddr(t))
+	defer client.Close()
+	q := newRedisQueue(client)
+
+	added, err := q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if err != nil || !added {
+		t.Log("Expected job to be added, got", added, err)
+		t.FailNow()
+	}
+
+	added, _ = q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if added {
+		t.Log("Expected duplicate job not to be added")
+		t.Fail()
+	}
+	if n := client.ZCard(redisQReady).Val(); n != 1 {
+		t.Log("Expected 1 ready job, got", n)
+		t.Fail()
+	}
+
+	job, err := q.Pop()
+	if err != nil || job == nil || job.Repo != "github.com/user/repo" || job.Attempts != 1 {
+		t.Log("Unexpected job", job, err)
+		t.FailNow()
+	}
+
+	// a running job is queued again once it is done, only once
+	added, _ = q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if !added {
+		t.Log("Expected running job to be queued again")
+		t.Fail()
+	}
+	added, _ = q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	if added {
+		t.Log("Expected running job not to be queued again twice")
+		t.Fail()
+	}
+
+	empty, _ := q.Pop()
+	if empty != nil {
+		t.Log("Expected running job to be hidden, got", empty)
+		t.Fail()
+	}
+
+	// the job is ready again after the visibility timeout
+	client.ZAdd(redisQRunning, redis.Z{Score: millis(time.Now().Add(-time.Second)), Member: job.ID})
+	job, _ = q.Pop()
+	if job == nil || job.Attempts != 2 {
+		t.Log("Expected job to be run again, got", job)
+		t.FailNow()
+	}
+
+	err = q.Nack(job, errors.New("docker is down"))
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	empty, _ = q.Pop()
+	if empty != nil {
+		t.Log("Expected nacked job to wait for the backoff, got", empty)
+		t.Fail()
+	}
+
+	client.ZAdd(redisQReady, redis.Z{Score: millis(time.Now()), Member: job.ID})
+	job, _ = q.Pop()
+	if job == nil || job.Attempts != queueMaxAttempts || job.LastError != "docker is down" {
+		t.Log("Expected job to be retried, got", job)
+		t.FailNow()
+	}
+
+	q.Nack(job, errors.New("docker is still down"))
+	dead, _ := q.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != job.ID || dead[0].LastError != "docker is still down" {
+		t.Log("Expected job in the dead letter list, got", dead)
+		t.Fail()
+	}
+
+	q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	job, _ = q.Pop()
+	q.Push(newJob("github.com/user/repo", "golang-1.10", ""))
+	q.Ack(job)
+	job, _ = q.Pop()
+	if job == nil || job.Attempts != 1 {
+		t.Log("Expected job pushed while running to be run again, got", job)
+		t.FailNow()
+	}
+
+	q.Ack(job)
+	if n := client.HLen(redisQJobs).Val() + client.ZCard(redisQRunning).Val() + client.SCard(redisQRerun).Val(); n != 0 {
+		t.Log("Expected empty queue after ack, got", n, "jobs")
+		t.Fail()
+	}
+
+	// an ID without its job is dropped, it is not left running
+	client.ZAdd(redisQReady, redis.Z{Score: millis(time.Now()), Member: "github.com/user/lost:golang-1.10"})
+	empty, err = q.Pop()
+	if empty != nil || err != nil || client.ZCard(redisQRunning).Val() != 0 || client.ZCard(redisQReady).Val() != 0 {
+		t.Log("Expected the ID without job to be dropped, got", empty, err)
+		t.Fail()
+	}
+}
+
+func TestLocalQueueDurable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cover")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cover.db")
+	ds, err := newDiskStore(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	q := newLocalQueue(ds)
+	q.Push(newJob("github.com/user/a", "golang-1.10", ""))
+	q.Push(newJob("github.com/user/b", "golang-1.10", ""))
+	q.Pop()
+	ds.Close()
+
+	ds, err = newDiskStore(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer ds.Close()
+
+	q = newLocalQueue(ds)
+	job, _ := q.Pop()
+	if job == nil || job.Repo != "github.com/user/b" {
+		t.Log("Expected queued job to survive a restart, got", job)
+		t.Fail()
+	}
+
+	if _, ok := q.state.Running[repoFullName("github.com/user/a", "golang-1.10", "")]; !ok {
+		t.Log("Expected running job to survive a restart")
+		t.Fail()
+	}
+}
+
+func TestJobVisibility(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	config = &Config{}
+	if v := jobVisibility(); v != queueVisibility {
+		t.Log("Expected the default visibility timeout, got", v)
+		t.Fail()
+	}
+
+	// a running job is hidden for longer than the longest cover run timeout, so it is not run
+	// twice
+	config = &Config{
+		Timeout: "10m",
+		Repos:   map[string]RepoConfig{"github.com/user/slow": {Timeout: "30m"}},
+	}
+	if d := config.maxTimeout(); d != 30*time.Minute {
+		t.Log("Expected the longest timeout to be 30m, got", d)
+		t.Fail()
+	}
+
+	q := newLocalQueue(newMemoryStore())
+	q.Push(newJob("github.com/user/slow", "golang-1.10", ""))
+	job, _ := q.Pop()
+	if job == nil {
+		t.Log("Expected a job")
+		t.FailNow()
+	}
+	deadline := time.Unix(0, q.state.Running[job.ID])
+	if deadline.Before(time.Now().Add(30*time.Minute + queueVisibilityMargin - time.Second)) {
+		t.Log("Expected the job to be hidden for longer than its timeout, got", deadline)
+		t.Fail()
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// reportPrefix is the prefix of the cache keys in which the coverage reports are saved
+	reportPrefix = "report:"
+
+	// Coverage state of a line in the report
+	lineNeutral   = ""
+	lineCovered   = "covered"
+	lineUncovered = "uncovered"
+	linePartial   = "partial"
+)
+
+// Report holds the coverage profile and the source of the covered files of a cover run,
+// it is used to render the HTML coverage report
+type Report struct {
+	Repo     string
+	Tag      string
+	Ref      string
+	Profiles []*Profile
+	// Sources is the source code of the covered files, by file name as in the profile
+	Sources map[string]string
+	// Commit and FinishedAt are the commit and end time of the cover run of the report
+	Commit     string
+	FinishedAt time.Time
+}
+
+// matches returns true if the report is the one of the result, a result saved by a later run
+// without report (e.g. whose tests failed) does not match the report of the previous run
+func (report *Report) matches(obj *Object) bool {
+	if report.Commit != "" && report.Commit == obj.Commit {
+		return true
+	}
+	return report.FinishedAt.Equal(obj.FinishedAt)
+}
+
+// reportLine is a single source line in the HTML report
+type reportLine struct {
+	Number int
+	Text   string
+	State  string
+}
+
+// reportFile is a single source file in the HTML report
+type reportFile struct {
+	ID         string
+	Name       string
+	Base       string
+	Statements int
+	Covered    int
+	Percent    float64
+	Lines      []reportLine
+}
+
+// reportDir is a directory (package) in the file tree of the HTML report
+type reportDir struct {
+	Name  string
+	Files []*reportFile
+}
+
+// reportPage is the data used to render the HTML report template
+type reportPage struct {
+	Repo    string
+	Tag     string
+	Ref     string
+	Commit  string
+	Cover   string
+	Message string
+	Dirs    []*reportDir
+	Files   []*reportFile
+}
+
+// reportKey returns the cache key of the coverage report of a repository
+func reportKey(repo, tag, ref string) string {
+	return reportPrefix + repoFullName(repo, tag, ref)
+}
+
+// lineStates returns the coverage state of every line of a file, by line number.
+// A line is partially covered if some of the blocks on it are covered and some are not.
+func lineStates(p *Profile) map[int]string {
+	states := make(map[int]string)
+	for _, b := range p.Blocks {
+		if b.NumStmt == 0 {
+			continue
+		}
+
+		state := lineUncovered
+		if b.Count > 0 {
+			state = lineCovered
+		}
+
+		for l := b.StartLine; l <= b.EndLine; l++ {
+			switch states[l] {
+			case lineNeutral:
+				states[l] = state
+			case state, linePartial:
+			default:
+				states[l] = linePartial
+			}
+		}
+	}
+	return states
+}
+
+// newReportPage prepares the report for rendering, files are grouped by their directory
+func newReportPage(obj *Object, report *Report) *reportPage {
+	page := &reportPage{
+		Repo:   obj.Repo,
+		Tag:    obj.Tag,
+		Ref:    obj.Ref,
+		Commit: obj.Commit,
+		Cover:  obj.Cover,
+	}
+
+	if report == nil {
+		page.Message = obj.Cover
+		if obj.Output {
+			page.Message = "Coverage report is not available"
+		}
+		return page
+	}
+
+	dirs := make(map[string]*reportDir)
+	for i, p := range report.Profiles {
+		total, covered := p.Statements()
+		f := &reportFile{
+			ID:         fmt.Sprintf("file%d", i),
+			Name:       p.FileName,
+			Base:       path.Base(p.FileName),
+			Statements: total,
+			Covered:    covered,
+			Percent:    percent(covered, total),
+		}
+
+		states := lineStates(p)
+		for n, text := range strings.Split(strings.TrimSuffix(report.Sources[p.FileName], "\n"), "\n") {
+			f.Lines = append(f.Lines, reportLine{
+				Number: n + 1,
+				Text:   text,
+				State:  states[n+1],
+			})
+		}
+
+		dirName := path.Dir(p.FileName)
+		dir := dirs[dirName]
+		if dir == nil {
+			dir = &reportDir{Name: dirName}
+			dirs[dirName] = dir
+			page.Dirs = append(page.Dirs, dir)
+		}
+		dir.Files = append(dir.Files, f)
+		page.Files = append(page.Files, f)
+	}
+
+	sort.Slice(page.Dirs, func(i, j int) bool {
+		return page.Dirs[i].Name < page.Dirs[j].Name
+	})
+
+	return page
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestNewReportPage(t *testing.T) {
+	profiles, err := parseProfiles(strings.NewReader(`mode: set
+github.com/user/repo/a.go:3.14,4.10 1 1
+github.com/user/repo/a.go:4.10,6.3 2 0
+github.com/user/repo/a.go:7.2,7.10 1 1
+`))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	obj := &Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Cover: "50.00%", Output: true}
+	page := newReportPage(obj, &Report{
+		Profiles: profiles,
+		Sources: map[string]string{
+			"github.com/user/repo/a.go": "package repo\n\nfunc a(b bool) {\n\tif b {\n\t\treturn\n\t}\n\ta()\n}\n",
+		},
+	})
+
+	if len(page.Dirs) != 1 || page.Dirs[0].Name != "github.com/user/repo" || len(page.Files) != 1 {
+		t.Log("Unexpected file tree", page.Dirs)
+		t.FailNow()
+	}
+
+	f := page.Files[0]
+	if len(f.Lines) != 8 || f.Base != "a.go" || f.Percent != 50 {
+		t.Log("Unexpected file", f.Base, len(f.Lines), f.Percent)
+		t.FailNow()
+	}
+
+	expected := []string{lineNeutral, lineNeutral, lineCovered, linePartial, lineUncovered, lineUncovered, lineCovered, lineNeutral}
+	for i, line := range f.Lines {
+		if line.State != expected[i] {
+			t.Log("Line", line.Number, "expected", expected[i], "got", line.State)
+			t.Fail()
+		}
+	}
+
+	err = reportTmpl.Execute(ioutil.Discard, page)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	page = newReportPage(obj, nil)
+	if page.Message == "" || len(page.Files) != 0 {
+		t.Log("Expected a message without report, got", page.Message)
+		t.Fail()
+	}
+}
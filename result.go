@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RunStatus is the state of the cover run of a repository
+type RunStatus string
+
+// States of a cover run
+const (
+	// StatusQueued is the status of a repository waiting in the queue
+	StatusQueued RunStatus = "queued"
+	// StatusRunning is the status of a repository whose coverage is being measured
+	StatusRunning RunStatus = "running"
+	// StatusPassed is the status of a repository whose tests passed, its coverage is known
+	StatusPassed RunStatus = "passed"
+	// StatusTestsFailed is the status of a repository whose tests failed
+	StatusTestsFailed RunStatus = "tests_failed"
+	// StatusNoTests is the status of a repository without test files
+	StatusNoTests RunStatus = "no_tests"
+	// StatusFetchFailed is the status of a repository which could not be fetched, or whose
+	// cover run could not be started
+	StatusFetchFailed RunStatus = "fetch_failed"
+	// StatusTimeout is the status of a repository whose cover run took longer than its
+	// timeout
+	StatusTimeout RunStatus = "timeout"
+	// StatusUnsupported is the status of a request for an unsupported Go version or an
+	// invalid ref
+	StatusUnsupported RunStatus = "unsupported"
+)
+
+// errorStatus returns the status of a cover run which failed with err
+func errorStatus(err error) RunStatus {
+	switch err {
+	case ErrQueued:
+		return StatusQueued
+	case ErrCovInPrgrs:
+		return StatusRunning
+	case ErrNoTest:
+		return StatusNoTests
+	case ErrTestsFailed:
+		return StatusTestsFailed
+	case ErrTimeout:
+		return StatusTimeout
+	case ErrImgUnSupported, ErrInvalidRef:
+		return StatusUnsupported
+	}
+	return StatusFetchFailed
+}
+
+// migrate sets the status, percentage and error of a result saved before they were added,
+// from its Cover text which held either the coverage or the error
+func (obj *Object) migrate() {
+	if obj.Status != "" {
+		return
+	}
+
+	if obj.Output {
+		obj.Status = StatusPassed
+		obj.Percent, _ = strconv.ParseFloat(strings.TrimSuffix(obj.Cover, "%"), 64)
+		return
+	}
+
+	obj.Error = obj.Cover
+	switch {
+	case obj.Cover == ErrTimeout.Error():
+		obj.Status = StatusTimeout
+	case obj.Cover == ErrNoTest.Error() || strings.HasPrefix(obj.Cover, "Error: No test files"):
+		obj.Status = StatusNoTests
+	case strings.HasPrefix(obj.Cover, "Error: Cannot test"):
+		obj.Status = StatusTestsFailed
+	case obj.Cover == ErrImgUnSupported.Error() || obj.Cover == ErrInvalidRef.Error():
+		obj.Status = StatusUnsupported
+	default:
+		obj.Status = StatusFetchFailed
+	}
+}
+
+// age returns the time since the result was measured at now, zero if unknown
+func (obj *Object) age(now time.Time) time.Duration {
+	if obj.FinishedAt.IsZero() {
+		return 0
+	}
+	return now.Sub(obj.FinishedAt)
+}
+
+// stale returns true if the result is outdated, older than cacheExpiry at now, or saved
+// without its time
+func (obj *Object) stale(now time.Time) bool {
+	return obj.Outdated || obj.FinishedAt.IsZero() || obj.age(now) >= cacheExpiry
+}
+
+// refreshDue returns true if the result is in its refresh window at now, refreshWindow
+// before it is stale, or stale. A result whose last refresh failed is not due before
+// refreshRetry.
+func (obj *Object) refreshDue(now time.Time) bool {
+	if !obj.LastAttemptAt.IsZero() && now.Sub(obj.LastAttemptAt) < refreshRetry {
+		return false
+	}
+	return obj.stale(now) || obj.age(now) >= cacheExpiry-refreshWindow
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObjectMigrate(t *testing.T) {
+	tt := []struct {
+		obj     Object
+		status  RunStatus
+		percent float64
+	}{
+		{Object{Cover: "66.67%", Output: true}, StatusPassed, 66.67},
+		{Object{Cover: "Error: Cannot test 'github.com/user/repo'"}, StatusTestsFailed, 0},
+		{Object{Cover: "Error: No test files for 'github.com/user/repo'"}, StatusNoTests, 0},
+		{Object{Cover: ErrTimeout.Error()}, StatusTimeout, 0},
+		{Object{Cover: ErrImgUnSupported.Error()}, StatusUnsupported, 0},
+		{Object{Cover: "fatal: repository not found"}, StatusFetchFailed, 0},
+		{Object{Cover: "Tests failed", Status: StatusTestsFailed}, StatusTestsFailed, 0},
+	}
+	for _, tc := range tt {
+		obj := tc.obj
+		obj.migrate()
+		if obj.Status != tc.status || obj.Percent != tc.percent {
+			t.Log(tc.obj.Cover, "expected", tc.status, tc.percent, "got", obj.Status, obj.Percent)
+			t.Fail()
+		}
+		if obj.Status != StatusPassed && tc.obj.Status == "" && obj.Error != tc.obj.Cover {
+			t.Log("Expected the error", tc.obj.Cover, "got", obj.Error)
+			t.Fail()
+		}
+	}
+
+	errs := map[error]RunStatus{
+		ErrQueued:      StatusQueued,
+		ErrCovInPrgrs:  StatusRunning,
+		ErrTestsFailed: StatusTestsFailed,
+		ErrNoTest:      StatusNoTests,
+		ErrFetchFailed: StatusFetchFailed,
+		ErrInvalidRef:  StatusUnsupported,
+	}
+	for err, expected := range errs {
+		if status := errorStatus(err); status != expected {
+			t.Log(err, "expected", expected, "got", status)
+			t.Fail()
+		}
+	}
+}
+
+func TestStaleResult(t *testing.T) {
+	oldStore, oldQueue := store, queue
+	defer func() {
+		store, queue = oldStore, oldQueue
+	}()
+	store = newMemoryStore()
+	lq := newLocalQueue(store)
+	queue = lq
+
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"fresh": 5 * time.Minute, "due": cacheExpiry - refreshWindow + time.Minute, "stale": 2 * time.Hour} {
+		repo := "github.com/user/" + name
+		saveCover(&Object{Repo: repo, Tag: "golang-1.10", Cover: "80.00%", Output: true, Status: StatusPassed, Percent: 80, StartedAt: now.Add(-age - time.Minute), FinishedAt: now.Add(-age)}, nil)
+
+		obj, color, status := coverageStatus(repo, "1.10", "", nil)
+		if color != "green" || status != "80.00%" {
+			t.Log(name, "expected the last result to be served, got", color, status)
+			t.Fail()
+		}
+		_, queued := lq.state.Jobs[repoFullName(repo, "golang-1.10", "")]
+		if queued != (name != "fresh") {
+			t.Log(name, "expected a refresh to be queued", name != "fresh", "got", queued)
+			t.Fail()
+		}
+
+		resp := newRepoResponse(obj, "")
+		if resp.Stale != (name == "stale") || resp.Age < int64(age/time.Second) || resp.Age > int64(age/time.Second)+5 {
+			t.Log(name, "unexpected stale", resp.Stale, "and age", resp.Age)
+			t.Fail()
+		}
+	}
+
+	// a result saved without its time is stale
+	saveCover(&Object{Repo: "github.com/user/old", Tag: "golang-1.10", Cover: "80.00%", Output: true}, nil)
+	obj, _ := repoCover("github.com/user/old", "1.10", "")
+	if _, queued := lq.state.Jobs[repoFullName(obj.Repo, obj.Tag, "")]; !queued || !obj.stale(now) || !newRepoResponse(obj, "").Stale {
+		t.Log("Expected a result without time to be stale and refreshed")
+		t.Fail()
+	}
+
+	// a queued repository has no result to be stale
+	obj, _ = repoCover("github.com/user/new", "1.10", "")
+	if obj.Status != StatusQueued || newRepoResponse(obj, "").Stale {
+		t.Log("Expected a queued repository not to be stale, got", obj.Status)
+		t.Fail()
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gofn/gofn/provision"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// Phases of a cover run, see run.sh
+	phaseFetch = "fetch"
+	phaseTest  = "test"
+
+	// workspace is where the workspace volume is mounted in the containers, it is the GOPATH
+	workspace = "/work"
+
+	// sandboxUser is the user the containers run as, nobody
+	sandboxUser = "65534:65534"
+
+	// exitNoTests is the exit code of run.sh when the repository has no test files
+	exitNoTests = 3
+)
+
+var (
+	// ErrFetchFailed is the error returned when the repository or its dependencies cannot
+	// be fetched
+	ErrFetchFailed = errors.New("Cannot fetch the repository")
+	// ErrTestsFailed is the error returned when the tests of the repository fail
+	ErrTestsFailed = errors.New("Tests failed")
+)
+
+// ContainerLimits are the resource limits of the containers of a cover run. A zero value is
+// unlimited, but for the fields of defaultLimits which are used instead.
+type ContainerLimits struct {
+	// MemoryMB is the memory limit, swap is not allowed
+	MemoryMB int64 `json:"memory_mb,omitempty"`
+	// CPUShares is the relative CPU weight, 1024 is the weight of other containers
+	CPUShares int64 `json:"cpu_shares,omitempty"`
+	// PIDs is the maximum number of processes and threads
+	PIDs int64 `json:"pids,omitempty"`
+	// TmpfsMB is the size of /tmp, in which the test binaries are built
+	TmpfsMB int64 `json:"tmpfs_mb,omitempty"`
+	// DiskMB is the size of the workspace volume, which holds the repository and its
+	// dependencies. It is a tmpfs, so it takes host memory as /tmp does.
+	DiskMB int64 `json:"disk_mb,omitempty"`
+}
+
+// defaultLimits are the limits of the containers if none are configured
+var defaultLimits = ContainerLimits{
+	MemoryMB:  1024,
+	CPUShares: 512,
+	PIDs:      512,
+	TmpfsMB:   256,
+	DiskMB:    256,
+}
+
+// merge returns the limits with the non zero values of other
+func (l ContainerLimits) merge(other *ContainerLimits) ContainerLimits {
+	if other == nil {
+		return l
+	}
+	if other.MemoryMB != 0 {
+		l.MemoryMB = other.MemoryMB
+	}
+	if other.CPUShares != 0 {
+		l.CPUShares = other.CPUShares
+	}
+	if other.PIDs != 0 {
+		l.PIDs = other.PIDs
+	}
+	if other.TmpfsMB != 0 {
+		l.TmpfsMB = other.TmpfsMB
+	}
+	if other.DiskMB != 0 {
+		l.DiskMB = other.DiskMB
+	}
+	return l
+}
+
+// versionLimits returns the limits of a Go version, the configured limits of the version
+// override the global ones, which override the defaults
+func versionLimits(v *GoVersion) ContainerLimits {
+	limits := defaultLimits.merge(config.Limits)
+	if v != nil {
+		limits = limits.merge(v.Limits)
+	}
+	return limits
+}
+
+// mb is a megabyte, in bytes
+const mb = 1024 * 1024
+
+// hostConfig returns the host configuration of a container with the limits. The root
+// filesystem is read only and all capabilities are dropped, only the workspace volume and
+// /tmp are writable. The container has network access only if network is true.
+func (l ContainerLimits) hostConfig(volume string, network bool) *docker.HostConfig {
+	hc := &docker.HostConfig{
+		Binds:          []string{volume + ":" + workspace},
+		ReadonlyRootfs: true,
+		CapDrop:        []string{"ALL"},
+		SecurityOpt:    []string{"no-new-privileges"},
+		NetworkMode:    "none",
+		Memory:         l.MemoryMB * mb,
+		MemorySwap:     l.MemoryMB * mb,
+		CPUShares:      l.CPUShares,
+		PidsLimit:      l.PIDs,
+		Tmpfs:          map[string]string{"/tmp": "rw,exec,nosuid,nodev"},
+	}
+	if l.TmpfsMB > 0 {
+		hc.Tmpfs["/tmp"] += fmt.Sprintf(",size=%dm", l.TmpfsMB)
+	}
+	if network {
+		hc.NetworkMode = "bridge"
+	}
+	return hc
+}
+
+// hostMemoryMB returns the most host memory a cover run takes: the memory limit of its
+// container, and /tmp and the workspace volume which are both held in memory
+func (l ContainerLimits) hostMemoryMB() int64 {
+	return l.MemoryMB + l.TmpfsMB + l.DiskMB
+}
+
+// volumeOptions returns the options of the workspace volume, a tmpfs owned by the sandbox
+// user whose size is the disk quota. The local volume driver has no quota for a volume on
+// disk, so the workspace is held in the host memory, see hostMemoryMB.
+func (l ContainerLimits) volumeOptions(name string) docker.CreateVolumeOptions {
+	opts := "uid=65534,gid=65534"
+	if l.DiskMB > 0 {
+		opts += fmt.Sprintf(",size=%dm", l.DiskMB)
+	}
+	return docker.CreateVolumeOptions{
+		Name:   name,
+		Driver: "local",
+		DriverOpts: map[string]string{
+			"type":   "tmpfs",
+			"device": "tmpfs",
+			"o":      opts,
+		},
+	}
+}
+
+// sandbox runs the phases of a cover run in containers with limits
+type sandbox struct {
+	client *docker.Client
+	image  string
+	limits ContainerLimits
+	volume string
+	// env are the additional environment variables of run.sh
+	env []string
+}
+
+// newSandbox creates the workspace volume of a cover run
+func newSandbox(image string, limits ContainerLimits, env []string) (*sandbox, error) {
+	client, err := provision.FnClient("")
+	if err != nil {
+		return nil, err
+	}
+
+	sb := &sandbox{
+		client: client,
+		image:  image,
+		limits: limits,
+		volume: "cover-" + uuid.NewV4().String(),
+		env:    env,
+	}
+	_, err = client.CreateVolume(limits.volumeOptions(sb.volume))
+	if err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// run runs a phase of run.sh in a new container, it returns the output and exit code of the
+// container. The output is returned even if the context is done before the container exits.
+func (sb *sandbox) run(ctx context.Context, phase string, network bool, args ...string) (string, string, int, error) {
+	container, err := sb.client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:      sb.image,
+			Cmd:        append([]string{"bash", "/run.sh", phase}, args...),
+			User:       sandboxUser,
+			WorkingDir: workspace,
+			Env: append([]string{
+				"GOPATH=" + workspace,
+				"GOCACHE=" + workspace + "/.cache",
+				"HOME=/tmp",
+			}, sb.env...),
+		},
+		HostConfig: sb.limits.hostConfig(sb.volume, network),
+	})
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer func() {
+		err := sb.client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+		if err != nil {
+			errLogger.Println(err)
+		}
+	}()
+
+	err = sb.client.StartContainer(container.ID, nil)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	code, err := sb.client.WaitContainerWithContext(container.ID, ctx)
+	if ctx.Err() != nil {
+		// the output written so far is kept, the container is killed when it is removed
+		err = ctx.Err()
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	logsErr := sb.client.Logs(docker.LogsOptions{
+		Container:    container.ID,
+		Stdout:       true,
+		Stderr:       true,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+	})
+	if logsErr != nil {
+		errLogger.Println(logsErr)
+	}
+	return stdout.String(), stderr.String(), code, err
+}
+
+// Close removes the workspace volume
+func (sb *sandbox) Close() error {
+	return sb.client.RemoveVolume(sb.volume)
+}
+
+// runSandboxed runs cover for the repo in the image with the limits. The repository and its
+// dependencies are fetched with network access, the tests are run without. ErrFetchFailed,
+// ErrNoTest or ErrTestsFailed is returned if run.sh fails. env are the additional environment
+// variables of run.sh, e.g. COVER_ALLOW_FAILURES.
+func runSandboxed(ctx context.Context, image string, limits ContainerLimits, env []string, repo, ref string) (string, string, error) {
+	sb, err := newSandbox(image, limits, env)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		// the volume is in use until the removed containers are gone
+		for attempt := 0; attempt < 3; attempt++ {
+			err := sb.Close()
+			if err == nil {
+				return
+			}
+			errLogger.Println(err)
+			time.Sleep(time.Second)
+		}
+	}()
+
+	stdout, stderr, code, err := sb.run(ctx, phaseFetch, true, repo, ref)
+	if err == nil && code != 0 {
+		err = ErrFetchFailed
+	}
+	if err != nil {
+		return stdout, stderr, err
+	}
+
+	stdout, stderr, code, err = sb.run(ctx, phaseTest, false, repo, ref)
+	switch {
+	case err != nil:
+	case code == exitNoTests:
+		err = ErrNoTest
+	case code != 0:
+		err = ErrTestsFailed
+	}
+	return stdout, stderr, err
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestContainerLimits(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+	config = &Config{Limits: &ContainerLimits{MemoryMB: 512, PIDs: 100}}
+
+	limits := versionLimits(&GoVersion{Limits: &ContainerLimits{MemoryMB: 2048}})
+	expected := ContainerLimits{MemoryMB: 2048, CPUShares: defaultLimits.CPUShares, PIDs: 100, TmpfsMB: defaultLimits.TmpfsMB, DiskMB: defaultLimits.DiskMB}
+	if limits != expected {
+		t.Log("Expected", expected, "got", limits)
+		t.Fail()
+	}
+	if limits = versionLimits(nil); limits.MemoryMB != 512 {
+		t.Log("Expected the global memory limit, got", limits)
+		t.Fail()
+	}
+
+	for _, network := range []bool{true, false} {
+		hc := expected.hostConfig("cover-volume", network)
+		if !hc.ReadonlyRootfs || len(hc.CapDrop) != 1 || hc.CapDrop[0] != "ALL" || hc.Memory != 2048*mb || hc.MemorySwap != hc.Memory || hc.PidsLimit != 100 {
+			t.Log("Unexpected host config", hc)
+			t.Fail()
+		}
+		if (hc.NetworkMode == "none") == network {
+			t.Log("Unexpected network mode", hc.NetworkMode, "with network", network)
+			t.Fail()
+		}
+		if hc.Binds[0] != "cover-volume:"+workspace || hc.Tmpfs["/tmp"] != "rw,exec,nosuid,nodev,size=256m" {
+			t.Log("Unexpected mounts", hc.Binds, hc.Tmpfs)
+			t.Fail()
+		}
+	}
+
+	if o := expected.volumeOptions("cover-volume").DriverOpts["o"]; o != "uid=65534,gid=65534,size=256m" {
+		t.Log("Unexpected volume options", o)
+		t.Fail()
+	}
+
+	// the workspace and /tmp are held in memory
+	if m := expected.hostMemoryMB(); m != 2048+256+256 {
+		t.Log("Expected the host memory to count the tmpfs, got", m)
+		t.Fail()
+	}
+}
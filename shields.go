@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// shieldsSchemaVersion is the version of the shields.io endpoint schema
+	shieldsSchemaVersion = 1
+	// defaultLabel is the label of the badges
+	defaultLabel = "cover.run"
+)
+
+var (
+	// ErrInvalidColor is the error returned when the badge color is neither a color name nor
+	// a hex color
+	ErrInvalidColor = errors.New("Invalid color")
+	// ErrInvalidThresholds is the error returned when the thresholds are not ascending
+	// percentages
+	ErrInvalidThresholds = errors.New("Invalid thresholds")
+)
+
+// badgeOptions are the label, color, coverage thresholds and logo of a badge set with the
+// `label`, `color`, `thresholds` and `logo` query string parameters
+type badgeOptions struct {
+	Label string
+	// Color replaces the color of the status
+	Color string
+	// Thresholds are the coverage percentages at which the color changes, nil for the
+	// default ones
+	Thresholds []float64
+	// Logo is the logo drawn before the label, nil if none
+	Logo *badgeLogo
+}
+
+// parseBadgeOptions returns the badge options of the query, e.g.
+// `label=coverage&color=blue&thresholds=45,70,90&logo=go`
+func parseBadgeOptions(query url.Values) (*badgeOptions, error) {
+	opts := &badgeOptions{
+		Label: strings.TrimSpace(query.Get("label")),
+		Color: strings.TrimSpace(query.Get("color")),
+	}
+	if opts.Color != "" && !validColor(opts.Color) {
+		return nil, ErrInvalidColor
+	}
+	logo, err := parseBadgeLogo(query)
+	if err != nil {
+		return nil, err
+	}
+	opts.Logo = logo
+
+	thresholds := strings.TrimSpace(query.Get("thresholds"))
+	if thresholds == "" {
+		return opts, nil
+	}
+	for _, s := range strings.Split(thresholds, ",") {
+		t, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsNaN(t) || t < 0 || t > 100 {
+			return nil, ErrInvalidThresholds
+		}
+		opts.Thresholds = append(opts.Thresholds, t)
+	}
+	ascending := sort.SliceIsSorted(opts.Thresholds, func(i, j int) bool {
+		return opts.Thresholds[i] <= opts.Thresholds[j]
+	})
+	if !ascending || len(opts.Thresholds) > len(thresholdPalettes) {
+		return nil, ErrInvalidThresholds
+	}
+	return opts, nil
+}
+
+// label returns the label of the badge, cover.run if not set
+func (opts *badgeOptions) label() string {
+	if opts == nil || opts.Label == "" {
+		return defaultLabel
+	}
+	return opts.Label
+}
+
+// color returns the color of the badge, the given color if not set
+func (opts *badgeOptions) color(color string) string {
+	if opts == nil || opts.Color == "" {
+		return color
+	}
+	return opts.Color
+}
+
+// ShieldsResponse is the JSON response of the shields.io endpoint badge, see
+// https://shields.io/endpoint
+type ShieldsResponse struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	// Color is the hex color of the message, without #
+	Color string `json:"color"`
+	// LogoSVG is the SVG of the logo, LogoWidth its width
+	LogoSVG   string `json:"logoSvg,omitempty"`
+	LogoWidth int    `json:"logoWidth,omitempty"`
+}
+
+// shieldsBadge returns the shields.io endpoint badge with the coverage of a repository
+func shieldsBadge(repo, tag, ref string, failing bool, opts *badgeOptions) *ShieldsResponse {
+	color, status := coverageBadgeStatus(repo, tag, ref, failing, opts)
+	resp := &ShieldsResponse{
+		SchemaVersion: shieldsSchemaVersion,
+		Label:         opts.label(),
+		Message:       status,
+		Color:         strings.TrimPrefix(badgeColor(color), "#"),
+	}
+	if opts.Logo != nil {
+		resp.LogoSVG = opts.Logo.svg("")
+		resp.LogoWidth = opts.Logo.Width
+	}
+	return resp
+}
+
+// HandlerRepoShields returns the coverage of a repository with the shields.io endpoint
+// schema, to render the badge with shields.io, e.g.
+// `https://img.shields.io/endpoint?url=https://cover.run/go/github.com/user/repo/shields.json`
+func HandlerRepoShields(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+	if tag == "" {
+		tag = DefaultTag
+	}
+	repo := strings.TrimSpace(mux.Vars(r)["repo"])
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+	failing := r.URL.Query().Get("failing") != ""
+
+	opts, err := parseBadgeOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shieldsBadge(repo, tag, ref, failing, opts))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseBadgeOptions(t *testing.T) {
+	valid := map[string]badgeOptions{
+		"":                                    {},
+		"label=coverage&color=blue":           {Label: "coverage", Color: "blue"},
+		"color=fe7d37":                        {Color: "fe7d37"},
+		"color=%234c1":                        {Color: "#4c1"},
+		"thresholds=45,70,90":                 {Thresholds: []float64{45, 70, 90}},
+		"thresholds=80":                       {Thresholds: []float64{80}},
+		"thresholds=10,20.5,30,40,50&label=x": {Label: "x", Thresholds: []float64{10, 20.5, 30, 40, 50}},
+	}
+	for query, expected := range valid {
+		q, _ := url.ParseQuery(query)
+		opts, err := parseBadgeOptions(q)
+		if err != nil {
+			t.Log(query, "unexpected error", err)
+			t.Fail()
+			continue
+		}
+		if opts.Label != expected.Label || opts.Color != expected.Color || len(opts.Thresholds) != len(expected.Thresholds) {
+			t.Log(query, "expected", expected, "got", *opts)
+			t.Fail()
+			continue
+		}
+		for i := range expected.Thresholds {
+			if opts.Thresholds[i] != expected.Thresholds[i] {
+				t.Log(query, "expected", expected.Thresholds, "got", opts.Thresholds)
+				t.Fail()
+			}
+		}
+	}
+
+	invalid := map[string]error{
+		"color=notacolor":              ErrInvalidColor,
+		"color=%23ff":                  ErrInvalidColor,
+		"color=red\"/><script>":        ErrInvalidColor,
+		"thresholds=70,45":             ErrInvalidThresholds,
+		"thresholds=45,a":              ErrInvalidThresholds,
+		"thresholds=101":               ErrInvalidThresholds,
+		"thresholds=NaN":               ErrInvalidThresholds,
+		"thresholds=45,nan":            ErrInvalidThresholds,
+		"thresholds=10,20,30,40,50,60": ErrInvalidThresholds,
+	}
+	for query, expected := range invalid {
+		q, _ := url.ParseQuery(query)
+		if _, err := parseBadgeOptions(q); err != expected {
+			t.Log(query, "expected", expected, "got", err)
+			t.Fail()
+		}
+	}
+}
+
+func TestThresholdColor(t *testing.T) {
+	cases := []struct {
+		cover      float64
+		thresholds []float64
+		color      string
+	}{
+		{30, nil, "red"},
+		{45, nil, "yellow"},
+		{70, nil, "green"},
+		{95, nil, "green"},
+		{69.9, []float64{45, 70, 90}, "yellow"},
+		{89, []float64{45, 70, 90}, "green"},
+		{90, []float64{45, 70, 90}, "brightgreen"},
+		{50, []float64{80}, "red"},
+	}
+	for _, c := range cases {
+		if color := thresholdColor(c.cover, c.thresholds); color != c.color {
+			t.Log(c.cover, c.thresholds, "expected", c.color, "got", color)
+			t.Fail()
+		}
+	}
+
+	for color, expected := range map[string]string{"blue": "#007ec6", "FE7D37": "#fe7d37", "#4C1": "#4c1", "nope": "#9a9a9a"} {
+		if got := badgeColor(color); got != expected {
+			t.Log(color, "expected", expected, "got", got)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerRepoShields(t *testing.T) {
+	oldStore := store
+	defer func() {
+		store = oldStore
+	}()
+	store = newMemoryStore()
+	saveCover(&Object{Repo: "github.com/user/repo", Tag: "golang-1.10", Cover: "92.00%", Output: true, Status: StatusPassed, Percent: 92}, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/go/{repo:.*}/shields.json", HandlerRepoShields)
+	r.HandleFunc("/go/{repo:.*}.svg", HandlerRepoSVG)
+
+	cases := map[string]ShieldsResponse{
+		"":                            {SchemaVersion: 1, Label: "cover.run", Message: "92.00%", Color: "96c40f"},
+		"thresholds=45,70,90":         {SchemaVersion: 1, Label: "cover.run", Message: "92.00%", Color: "4c1"},
+		"label=coverage&color=ff69b4": {SchemaVersion: 1, Label: "coverage", Message: "92.00%", Color: "ff69b4"},
+	}
+	for query, expected := range cases {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/shields.json?tag=1.10&"+query, nil))
+		resp := ShieldsResponse{}
+		err := json.NewDecoder(rec.Body).Decode(&resp)
+		if err != nil {
+			t.Log(query, err)
+			t.Fail()
+			continue
+		}
+		if resp != expected {
+			t.Log(query, "expected", expected, "got", resp)
+			t.Fail()
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/shields.json?color=nope", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Log("Expected", http.StatusBadRequest, "got", rec.Code)
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo.svg?tag=1.10&label=%3Cb%3E&thresholds=45,70,90", nil))
+	svg := rec.Body.String()
+	if !strings.Contains(svg, ">&lt;b&gt;<") || strings.Contains(svg, "<b>") || !strings.Contains(svg, `fill="#4c1"`) {
+		t.Log("Expected an escaped label and a bright green badge, got", svg)
+		t.Fail()
+	}
+}
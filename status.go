@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// State of the commit status of a pull request
+	statusPending = "pending"
+	statusSuccess = "success"
+	statusFailure = "failure"
+	statusError   = "error"
+
+	// statusContext is the name of the commit status of cover.run
+	statusContext = "cover.run"
+
+	// APIs of the git hosts, if no other is configured
+	githubAPI = "https://api.github.com"
+	gitlabAPI = "https://gitlab.com/api/v4"
+)
+
+// ErrStatusProvider is the error returned for an installation of an unknown git host
+var ErrStatusProvider = errors.New("Unsupported status provider")
+
+// StatusProvider reports the coverage of the pull requests to a git host
+type StatusProvider interface {
+	// SetStatus sets the cover.run status of the head commit of the pull request
+	SetStatus(pr *PullRequest, state, description, targetURL string) error
+	// Comment adds a comment to the pull request, or updates the comment id if not 0. It
+	// returns the id of the comment.
+	Comment(pr *PullRequest, id int64, body string) (int64, error)
+}
+
+// newStatusProvider returns the status provider of the installation
+func newStatusProvider(inst *InstallationConfig) (StatusProvider, error) {
+	switch inst.Provider {
+	case hookGitHub:
+		api := inst.APIURL
+		if api == "" {
+			api = githubAPI
+		}
+		return &githubStatus{api: strings.TrimSuffix(api, "/"), token: inst.Token}, nil
+	case hookGitLab:
+		api := inst.APIURL
+		if api == "" {
+			api = gitlabAPI
+		}
+		return &gitlabStatus{api: strings.TrimSuffix(api, "/"), token: inst.Token}, nil
+	}
+	return nil, fmt.Errorf("%s: %s", ErrStatusProvider, inst.Provider)
+}
+
+// repoPath returns the path of the repo on its host, e.g. user/project for
+// github.com/user/project or group/sub/project for gitlab.com/group/sub/project
+func repoPath(repo string) string {
+	if i := strings.Index(repo, "/"); i > -1 {
+		return repo[i+1:]
+	}
+	return repo
+}
+
+// apiError is the error of a request to the API of a git host which did not succeed
+type apiError struct {
+	url    string
+	status string
+	code   int
+	msg    []byte
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.url, e.status, e.msg)
+}
+
+// notFound returns true if err is the error of an API request to a missing resource
+func notFound(err error) bool {
+	apiErr, ok := err.(*apiError)
+	return ok && apiErr.code == http.StatusNotFound
+}
+
+// sendJSON sends v as JSON to the URL with the given method and headers, the JSON response is
+// decoded in out if not nil
+func sendJSON(method, u string, header http.Header, v, out interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &apiError{url: u, status: resp.Status, code: resp.StatusCode, msg: msg}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiComment is the comment or note returned by the APIs of the git hosts
+type apiComment struct {
+	ID int64 `json:"id"`
+}
+
+// githubStatus reports to GitHub with the commit status and issue comment APIs
+type githubStatus struct {
+	api   string
+	token string
+}
+
+func (gh *githubStatus) header() http.Header {
+	return http.Header{
+		"Authorization": {"token " + gh.token},
+		"Accept":        {"application/vnd.github.v3+json"},
+	}
+}
+
+// SetStatus creates a commit status
+func (gh *githubStatus) SetStatus(pr *PullRequest, state, description, targetURL string) error {
+	u := fmt.Sprintf("%s/repos/%s/statuses/%s", gh.api, pr.path(), pr.Commit)
+	return sendJSON(http.MethodPost, u, gh.header(), map[string]string{
+		"state":       state,
+		"description": description,
+		"target_url":  targetURL,
+		"context":     statusContext,
+	}, nil)
+}
+
+// Comment creates a comment on the pull request, which is an issue for the API, or updates
+// the comment id. A deleted comment is created again.
+func (gh *githubStatus) Comment(pr *PullRequest, id int64, body string) (int64, error) {
+	comment := &apiComment{}
+	if id != 0 {
+		u := fmt.Sprintf("%s/repos/%s/issues/comments/%d", gh.api, pr.path(), id)
+		err := sendJSON(http.MethodPatch, u, gh.header(), map[string]string{"body": body}, comment)
+		if !notFound(err) {
+			return id, err
+		}
+	}
+
+	u := fmt.Sprintf("%s/repos/%s/issues/%d/comments", gh.api, pr.path(), pr.Number)
+	err := sendJSON(http.MethodPost, u, gh.header(), map[string]string{"body": body}, comment)
+	return comment.ID, err
+}
+
+// gitlabStatus reports to GitLab with the commit status and merge request note APIs
+type gitlabStatus struct {
+	api   string
+	token string
+}
+
+func (gl *gitlabStatus) header() http.Header {
+	return http.Header{"Private-Token": {gl.token}}
+}
+
+// gitlabStates are the GitLab names of the commit states
+var gitlabStates = map[string]string{
+	statusPending: "running",
+	statusSuccess: "success",
+	statusFailure: "failed",
+	statusError:   "failed",
+}
+
+// SetStatus creates a commit status
+func (gl *gitlabStatus) SetStatus(pr *PullRequest, state, description, targetURL string) error {
+	u := fmt.Sprintf("%s/projects/%s/statuses/%s", gl.api, url.PathEscape(pr.path()), pr.Commit)
+	return sendJSON(http.MethodPost, u, gl.header(), map[string]string{
+		"state":       gitlabStates[state],
+		"description": description,
+		"target_url":  targetURL,
+		"name":        statusContext,
+	}, nil)
+}
+
+// Comment creates a note on the merge request, or updates the note id. A deleted note is
+// created again.
+func (gl *gitlabStatus) Comment(pr *PullRequest, id int64, body string) (int64, error) {
+	notes := fmt.Sprintf("%s/projects/%s/merge_requests/%d/notes", gl.api, url.PathEscape(pr.path()), pr.Number)
+	note := &apiComment{}
+	if id != 0 {
+		err := sendJSON(http.MethodPut, fmt.Sprintf("%s/%d", notes, id), gl.header(), map[string]string{"body": body}, note)
+		if !notFound(err) {
+			return id, err
+		}
+	}
+
+	err := sendJSON(http.MethodPost, notes, gl.header(), map[string]string{"body": body}, note)
+	return note.ID, err
+}
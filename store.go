@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+)
+
+const (
+	// latestMax is the maximum number of keys kept in the list of recent results
+	latestMax = 100
+
+	// Supported storage backends
+	storeRedis  = "redis"
+	storeMemory = "memory"
+	storeDisk   = "disk"
+)
+
+var (
+	// ErrNotFound is the error returned by a Store when a key does not exist or is expired
+	ErrNotFound = errors.New("store: key not found")
+	// ErrStoreUnSupported is the error returned when an unknown storage backend is configured
+	ErrStoreUnSupported = errors.New("Unsupported storage backend")
+)
+
+// Store is the storage backend of cover.run. It holds the cached results, the set of
+// cover runs in progress, the list of recent results and the history of the results.
+type Store interface {
+	// Get decodes the value saved with the key into v, it returns ErrNotFound if the key
+	// does not exist or is expired
+	Get(key string, v interface{}) error
+	// Set saves v with the key, a zero or negative expiration never expires
+	Set(key string, v interface{}, expiration time.Duration) error
+	// Delete removes the key, it is not an error if the key does not exist
+	Delete(key string) error
+
+	// SetInProgress marks the cover run with the given name (repo + tag) as in progress
+	SetInProgress(name string) error
+	// UnsetInProgress removes the in progress mark of a cover run
+	UnsetInProgress(name string) error
+	// InProgress returns true if the cover run with the given name is in progress
+	InProgress(name string) (bool, error)
+
+	// PushLatest adds a key to the top of the list of recent results
+	PushLatest(key string) error
+	// Latest returns up to n keys of the recent results, the most recent first
+	Latest(n int) ([]string, error)
+
+	// AddHistory adds a result to the history of the cover run with the given name, only the
+	// historyMax most recent results are kept
+	AddHistory(name string, entry *HistoryEntry) error
+	// History returns up to limit of the most recent results of the cover run between from
+	// and to, the oldest first. A zero from or to is unbounded.
+	History(name string, from, to time.Time, limit int) ([]*HistoryEntry, error)
+}
+
+// encode and decode are the codec used by the stores to save values
+func encode(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func decode(b []byte, v interface{}) error {
+	return msgpack.Unmarshal(b, v)
+}
+
+// newStore returns the storage backend with the given name
+// - addr is the address of the Redis server
+// - path is the path of the database file of the disk store
+func newStore(name, addr, path string) (Store, error) {
+	switch name {
+	case storeRedis:
+		return newRedisStore(addr), nil
+	case storeMemory:
+		return newMemoryStore(), nil
+	case storeDisk:
+		return newDiskStore(path)
+	}
+	return nil, fmt.Errorf("%s: %s", ErrStoreUnSupported, name)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Operations saved in the log of the disk store
+	diskOpSet     = 1
+	diskOpLatest  = 2
+	diskOpDelete  = 3
+	diskOpHistory = 4
+
+	// diskCompactMin is the minimum number of records in the log before it is compacted
+	diskCompactMin = 1000
+	// diskRecordMax is the maximum size of a record, in bytes. A report with the sources of
+	// a large repository is a few megabytes.
+	diskRecordMax = 64 * 1024 * 1024
+)
+
+// ErrDiskRecordSize is the error returned when a record of the disk store is larger than
+// diskRecordMax, a larger size read from the log is a corrupted record
+var ErrDiskRecordSize = errors.New("Disk store record too large")
+
+// diskRecord is a single change saved in the log of the disk store
+type diskRecord struct {
+	Op        uint8
+	Key       string
+	Value     []byte
+	ExpiresAt int64
+}
+
+// diskStore is an embedded Store, which keeps everything in memory and saves every change to
+// an append only log file. The log is replayed when the store is opened, and compacted when
+// it has grown much larger than the data it holds. All the data is read on every request of
+// the badges, so it is kept in memory rather than in an embedded database which would need
+// a dependency. The memory taken is the size of the compacted log: the results and reports,
+// with the sources of the measured files, of the cover runs of the last resultRetention.
+// The in progress cover runs are not saved, since they do not survive a restart anyway.
+type diskStore struct {
+	*memoryStore
+
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	records int
+}
+
+// newDiskStore opens the disk store saved at path, the file is created if it does not exist
+func newDiskStore(path string) (*diskStore, error) {
+	ds := &diskStore{
+		memoryStore: newMemoryStore(),
+		path:        path,
+	}
+
+	err := ds.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ds.file, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// load replays the log. An incomplete record at the end of the log (e.g. after a crash), or a
+// corrupted one, is dropped with the records after it.
+func (ds *diskStore) load() error {
+	f, err := os.OpenFile(ds.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	offset := int64(0)
+	for {
+		rec, n, err := readDiskRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			errLogger.Println("dropping incomplete or corrupted records from", ds.path, err)
+			return f.Truncate(offset)
+		}
+		offset += n
+		ds.records++
+		ds.apply(rec)
+	}
+}
+
+// apply applies the record to the memory store
+func (ds *diskStore) apply(rec *diskRecord) {
+	ds.memoryStore.mu.Lock()
+	defer ds.memoryStore.mu.Unlock()
+
+	switch rec.Op {
+	case diskOpSet:
+		item := &memoryItem{Value: rec.Value}
+		if rec.ExpiresAt > 0 {
+			item.ExpiresAt = time.Unix(0, rec.ExpiresAt)
+		}
+		ds.memoryStore.setItem(rec.Key, item)
+	case diskOpLatest:
+		ds.memoryStore.pushLatest(rec.Key)
+	case diskOpDelete:
+		delete(ds.memoryStore.items, rec.Key)
+	case diskOpHistory:
+		entry := &HistoryEntry{}
+		err := decode(rec.Value, entry)
+		if err != nil {
+			errLogger.Println("dropping invalid history record from", ds.path, err)
+			return
+		}
+		ds.memoryStore.addHistory(rec.Key, entry)
+	}
+}
+
+// readDiskRecord reads a single record, it returns the record and the number of bytes read
+func readDiskRecord(r io.Reader) (*diskRecord, int64, error) {
+	var size uint32
+	// io.EOF is returned as is only if there is no more record to read
+	err := binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return nil, 0, err
+	}
+	if size > diskRecordMax {
+		return nil, 0, ErrDiskRecordSize
+	}
+
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	rec := &diskRecord{}
+	err = decode(b, rec)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rec, int64(size) + 4, nil
+}
+
+// writeDiskRecord writes a single record prefixed with its size
+func writeDiskRecord(w io.Writer, rec *diskRecord) error {
+	b, err := encode(rec)
+	if err != nil {
+		return err
+	}
+	if len(b) > diskRecordMax {
+		return ErrDiskRecordSize
+	}
+
+	err = binary.Write(w, binary.BigEndian, uint32(len(b)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// append applies the record and saves it to the log. The caller must hold the lock.
+func (ds *diskStore) append(rec *diskRecord) error {
+	err := writeDiskRecord(ds.file, rec)
+	if err != nil {
+		return err
+	}
+
+	err = ds.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	ds.apply(rec)
+	ds.records++
+
+	return ds.compact()
+}
+
+// compact rewrites the log with only the live data, if the log has grown too large.
+// The caller must hold the lock.
+func (ds *diskStore) compact() error {
+	ds.memoryStore.mu.RLock()
+	live := len(ds.memoryStore.items) + len(ds.memoryStore.latest)
+	for _, history := range ds.memoryStore.history {
+		live += len(history)
+	}
+	ds.memoryStore.mu.RUnlock()
+
+	if ds.records < diskCompactMin || ds.records < live*2 {
+		return nil
+	}
+
+	// the new log is kept open once renamed, so the store keeps a log to append to whether
+	// the compaction fails or not
+	tmpPath := ds.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_APPEND|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	records, err := ds.snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, ds.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	ds.file.Close()
+	ds.file = f
+	ds.records = records
+	return nil
+}
+
+// snapshot writes all the live data of the memory store as records, it returns the number
+// of records written
+func (ds *diskStore) snapshot(w io.Writer) (int, error) {
+	ds.memoryStore.mu.RLock()
+	defer ds.memoryStore.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	records := 0
+	now := time.Now()
+	for key, item := range ds.memoryStore.items {
+		if item.expired(now) {
+			continue
+		}
+
+		rec := &diskRecord{Op: diskOpSet, Key: key, Value: item.Value}
+		if !item.ExpiresAt.IsZero() {
+			rec.ExpiresAt = item.ExpiresAt.UnixNano()
+		}
+		err := writeDiskRecord(bw, rec)
+		if err != nil {
+			return records, err
+		}
+		records++
+	}
+
+	// pushed from the oldest, so the most recent ends up at the top when replayed
+	for i := len(ds.memoryStore.latest) - 1; i >= 0; i-- {
+		err := writeDiskRecord(bw, &diskRecord{Op: diskOpLatest, Key: ds.memoryStore.latest[i]})
+		if err != nil {
+			return records, err
+		}
+		records++
+	}
+
+	for name, history := range ds.memoryStore.history {
+		for _, entry := range history {
+			b, err := encode(entry)
+			if err != nil {
+				return records, err
+			}
+			err = writeDiskRecord(bw, &diskRecord{Op: diskOpHistory, Key: name, Value: b})
+			if err != nil {
+				return records, err
+			}
+			records++
+		}
+	}
+
+	return records, bw.Flush()
+}
+
+// Set saves v with the key
+func (ds *diskStore) Set(key string, v interface{}, expiration time.Duration) error {
+	b, err := encode(v)
+	if err != nil {
+		return err
+	}
+
+	rec := &diskRecord{Op: diskOpSet, Key: key, Value: b}
+	if expiration > 0 {
+		rec.ExpiresAt = expiresAt(expiration).UnixNano()
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.append(rec)
+}
+
+// Delete removes the key
+func (ds *diskStore) Delete(key string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.append(&diskRecord{Op: diskOpDelete, Key: key})
+}
+
+// PushLatest adds the key to the top of the recent results
+func (ds *diskStore) PushLatest(key string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.append(&diskRecord{Op: diskOpLatest, Key: key})
+}
+
+// AddHistory adds the result to the history of the cover run
+func (ds *diskStore) AddHistory(name string, entry *HistoryEntry) error {
+	b, err := encode(entry)
+	if err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.append(&diskRecord{Op: diskOpHistory, Key: name, Value: b})
+}
+
+// Close closes the log file
+func (ds *diskStore) Close() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.file.Close()
+}
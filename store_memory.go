@@ -0,0 +1,213 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is the minimum interval between two sweeps of the expired items, the
+// expired items read meanwhile are dropped by Get
+const memorySweepInterval = time.Minute
+
+// memoryItem is a value saved in the memory store
+type memoryItem struct {
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+// expired returns true if the item is expired at the given time
+func (item *memoryItem) expired(now time.Time) bool {
+	return !item.ExpiresAt.IsZero() && now.After(item.ExpiresAt)
+}
+
+// memoryStore is a Store which keeps everything in memory, nothing survives a restart
+type memoryStore struct {
+	mu         sync.RWMutex
+	items      map[string]*memoryItem
+	inProgress map[string]bool
+	latest     []string
+	history    map[string][]*HistoryEntry
+	// swept is the time of the last sweep of the expired items
+	swept time.Time
+}
+
+// newMemoryStore returns a new empty memory store
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		items:      make(map[string]*memoryItem),
+		inProgress: make(map[string]bool),
+		latest:     make([]string, 0),
+		history:    make(map[string][]*HistoryEntry),
+	}
+}
+
+// expiresAt returns the expiry time for the given expiration, zero time never expires
+func expiresAt(expiration time.Duration) time.Time {
+	if expiration <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(expiration)
+}
+
+// Get decodes the value saved with the key into v
+func (ms *memoryStore) Get(key string, v interface{}) error {
+	ms.mu.RLock()
+	item, ok := ms.items[key]
+	ms.mu.RUnlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+	if item.expired(time.Now()) {
+		ms.mu.Lock()
+		if ms.items[key] == item {
+			delete(ms.items, key)
+		}
+		ms.mu.Unlock()
+		return ErrNotFound
+	}
+	return decode(item.Value, v)
+}
+
+// Set saves v with the key
+func (ms *memoryStore) Set(key string, v interface{}, expiration time.Duration) error {
+	b, err := encode(v)
+	if err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	ms.setItem(key, &memoryItem{Value: b, ExpiresAt: expiresAt(expiration)})
+	ms.mu.Unlock()
+	return nil
+}
+
+// Delete removes the key
+func (ms *memoryStore) Delete(key string) error {
+	ms.mu.Lock()
+	delete(ms.items, key)
+	ms.mu.Unlock()
+	return nil
+}
+
+// setItem saves the item, it also drops the expired items if they were not swept for
+// memorySweepInterval. The caller must hold the lock.
+func (ms *memoryStore) setItem(key string, item *memoryItem) {
+	ms.items[key] = item
+
+	now := time.Now()
+	if now.Sub(ms.swept) < memorySweepInterval {
+		return
+	}
+	ms.swept = now
+	for k, it := range ms.items {
+		if it.expired(now) {
+			delete(ms.items, k)
+		}
+	}
+}
+
+// SetInProgress marks the cover run as in progress
+func (ms *memoryStore) SetInProgress(name string) error {
+	ms.mu.Lock()
+	ms.inProgress[name] = true
+	ms.mu.Unlock()
+	return nil
+}
+
+// UnsetInProgress removes the in progress mark of the cover run
+func (ms *memoryStore) UnsetInProgress(name string) error {
+	ms.mu.Lock()
+	delete(ms.inProgress, name)
+	ms.mu.Unlock()
+	return nil
+}
+
+// InProgress returns true if the cover run is in progress
+func (ms *memoryStore) InProgress(name string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.inProgress[name], nil
+}
+
+// PushLatest adds the key to the top of the recent results
+func (ms *memoryStore) PushLatest(key string) error {
+	ms.mu.Lock()
+	ms.pushLatest(key)
+	ms.mu.Unlock()
+	return nil
+}
+
+// pushLatest adds the key to the top of the recent results. The caller must hold the lock.
+func (ms *memoryStore) pushLatest(key string) {
+	latest := make([]string, 0, len(ms.latest)+1)
+	latest = append(latest, key)
+	for _, k := range ms.latest {
+		if k != key && len(latest) < latestMax {
+			latest = append(latest, k)
+		}
+	}
+	ms.latest = latest
+}
+
+// Latest returns up to n keys of the recent results
+func (ms *memoryStore) Latest(n int) ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if n > len(ms.latest) {
+		n = len(ms.latest)
+	}
+	keys := make([]string, n)
+	copy(keys, ms.latest[:n])
+	return keys, nil
+}
+
+// AddHistory adds the result to the history of the cover run
+func (ms *memoryStore) AddHistory(name string, entry *HistoryEntry) error {
+	ms.mu.Lock()
+	ms.addHistory(name, entry)
+	ms.mu.Unlock()
+	return nil
+}
+
+// addHistory adds the result to the history, which is kept sorted by time. The caller must
+// hold the lock.
+func (ms *memoryStore) addHistory(name string, entry *HistoryEntry) {
+	history := ms.history[name]
+	i := len(history)
+	for i > 0 && history[i-1].Time.After(entry.Time) {
+		i--
+	}
+	history = append(history, nil)
+	copy(history[i+1:], history[i:])
+	history[i] = entry
+
+	if len(history) > historyMax {
+		history = history[len(history)-historyMax:]
+	}
+	ms.history[name] = history
+}
+
+// History returns up to limit of the most recent results of the cover run between from and to
+func (ms *memoryStore) History(name string, from, to time.Time, limit int) ([]*HistoryEntry, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	history := ms.history[name]
+	end := len(history)
+	for end > 0 && !to.IsZero() && history[end-1].Time.After(to) {
+		end--
+	}
+	start := end
+	for start > 0 && end-start < limit && inRange(history[start-1].Time, from, to) {
+		start--
+	}
+
+	entries := make([]*HistoryEntry, 0, end-start)
+	for _, entry := range history[start:end] {
+		e := *entry
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
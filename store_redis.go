@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/cache"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// latestKey is the redis list key in which the keys of the recent results are saved
+	latestKey = "cover-latest"
+	// historyKey is the prefix of the redis sorted sets in which the history of the cover
+	// runs is saved, scored by time
+	historyKey = "cover-history:"
+)
+
+// redisStore is a Store backed by Redis
+type redisStore struct {
+	ring  *redis.Ring
+	codec *cache.Codec
+}
+
+// newRedisStore returns a Store using the Redis server at the given address
+func newRedisStore(addr string) *redisStore {
+	ring := redis.NewRing(&redis.RingOptions{
+		Addrs: map[string]string{
+			"server1": addr,
+		},
+	})
+
+	return &redisStore{
+		ring: ring,
+		codec: &cache.Codec{
+			Redis:     ring,
+			Marshal:   encode,
+			Unmarshal: decode,
+		},
+	}
+}
+
+// Get decodes the value saved with the key into v
+func (rs *redisStore) Get(key string, v interface{}) error {
+	err := rs.codec.Get(key, v)
+	if err == cache.ErrCacheMiss {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Set saves v with the key
+func (rs *redisStore) Set(key string, v interface{}, expiration time.Duration) error {
+	if expiration <= 0 {
+		// negative expiration never expires in go-redis/cache
+		expiration = -1
+	}
+
+	return rs.codec.Set(&cache.Item{
+		Key:        key,
+		Object:     v,
+		Expiration: expiration,
+	})
+}
+
+// Delete removes the key
+func (rs *redisStore) Delete(key string) error {
+	err := rs.codec.Delete(key)
+	if err == cache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+// SetInProgress marks the cover run as in progress by adding it to the inProgrsKey hash
+func (rs *redisStore) SetInProgress(name string) error {
+	return rs.ring.HSet(inProgrsKey, name, "y").Err()
+}
+
+// UnsetInProgress removes the cover run from the inProgrsKey hash
+func (rs *redisStore) UnsetInProgress(name string) error {
+	return rs.ring.HDel(inProgrsKey, name).Err()
+}
+
+// InProgress returns true if the cover run is in the inProgrsKey hash
+func (rs *redisStore) InProgress(name string) (bool, error) {
+	err := rs.ring.HGet(inProgrsKey, name).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PushLatest adds the key to the top of the latestKey list
+func (rs *redisStore) PushLatest(key string) error {
+	_, err := rs.ring.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.LRem(latestKey, 0, key)
+		pipe.LPush(latestKey, key)
+		pipe.LTrim(latestKey, 0, latestMax-1)
+		return nil
+	})
+	return err
+}
+
+// Latest returns up to n keys of the latestKey list
+func (rs *redisStore) Latest(n int) ([]string, error) {
+	if n < 1 {
+		return []string{}, nil
+	}
+	return rs.ring.LRange(latestKey, 0, int64(n-1)).Result()
+}
+
+// AddHistory adds the result to the historyKey sorted set of the cover run
+func (rs *redisStore) AddHistory(name string, entry *HistoryEntry) error {
+	b, err := encode(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = rs.ring.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(historyKey+name, redis.Z{Score: millis(entry.Time), Member: string(b)})
+		pipe.ZRemRangeByRank(historyKey+name, 0, -historyMax-1)
+		return nil
+	})
+	return err
+}
+
+// History returns up to limit of the most recent results of the historyKey sorted set
+func (rs *redisStore) History(name string, from, to time.Time, limit int) ([]*HistoryEntry, error) {
+	opt := redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: int64(limit)}
+	if !from.IsZero() {
+		opt.Min = strconv.FormatFloat(millis(from), 'f', 0, 64)
+	}
+	if !to.IsZero() {
+		opt.Max = strconv.FormatFloat(millis(to), 'f', 0, 64)
+	}
+
+	members, err := rs.ring.ZRevRangeByScore(historyKey+name, opt).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*HistoryEntry, len(members))
+	for i, member := range members {
+		entry := &HistoryEntry{}
+		err = decode([]byte(member), entry)
+		if err != nil {
+			return nil, err
+		}
+		// the most recent first in the sorted set
+		entries[len(members)-1-i] = entry
+	}
+	return entries, nil
+}
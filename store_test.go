@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testStore runs the common Store checks against the given store
+func testStore(t *testing.T, s Store) {
+	obj := &Object{}
+	err := s.Get("github.com/user/repo:golang-1.10", obj)
+	if err != ErrNotFound {
+		t.Log("Expected", ErrNotFound, "got", err)
+		t.Fail()
+	}
+
+	err = s.Set("github.com/user/repo:golang-1.10", &Object{Repo: "github.com/user/repo", Cover: "10.00%"}, time.Hour)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	err = s.Get("github.com/user/repo:golang-1.10", obj)
+	if err != nil || obj.Repo != "github.com/user/repo" || obj.Cover != "10.00%" {
+		t.Log("Unexpected object", obj, err)
+		t.Fail()
+	}
+
+	err = s.Set("expired", "value", time.Nanosecond)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	time.Sleep(time.Millisecond)
+	str := ""
+	err = s.Get("expired", &str)
+	if err != ErrNotFound {
+		t.Log("Expected", ErrNotFound, "for expired key, got", err, str)
+		t.Fail()
+	}
+
+	err = s.Set("deleted", "value", 0)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	err = s.Delete("deleted")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	err = s.Get("deleted", &str)
+	if err != ErrNotFound {
+		t.Log("Expected", ErrNotFound, "for deleted key, got", err, str)
+		t.Fail()
+	}
+	err = s.Delete("missing")
+	if err != nil {
+		t.Log("Expected no error deleting a missing key, got", err)
+		t.Fail()
+	}
+
+	inprogress, err := s.InProgress("github.com/user/repo:golang-1.10")
+	if err != nil || inprogress {
+		t.Log("Expected not in progress, got", inprogress, err)
+		t.Fail()
+	}
+	s.SetInProgress("github.com/user/repo:golang-1.10")
+	inprogress, _ = s.InProgress("github.com/user/repo:golang-1.10")
+	if !inprogress {
+		t.Log("Expected in progress")
+		t.Fail()
+	}
+	s.UnsetInProgress("github.com/user/repo:golang-1.10")
+	inprogress, _ = s.InProgress("github.com/user/repo:golang-1.10")
+	if inprogress {
+		t.Log("Expected not in progress")
+		t.Fail()
+	}
+
+	for _, key := range []string{"a", "b", "c", "a"} {
+		err = s.PushLatest(key)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+	keys, err := s.Latest(5)
+	if err != nil || len(keys) != 3 || keys[0] != "a" || keys[1] != "c" || keys[2] != "b" {
+		t.Log("Expected [a c b], got", keys, err)
+		t.Fail()
+	}
+	keys, _ = s.Latest(1)
+	if len(keys) != 1 {
+		t.Log("Expected 1 key, got", keys)
+		t.Fail()
+	}
+
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// added out of order, the history is sorted by time
+	for _, day := range []int{0, 2, 1, 3} {
+		err = s.AddHistory("github.com/user/repo:golang-1.10", &HistoryEntry{
+			Time:   start.AddDate(0, 0, day),
+			Commit: strconv.Itoa(day),
+		})
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+	history, err := s.History("github.com/user/repo:golang-1.10", time.Time{}, time.Time{}, 10)
+	if err != nil || len(history) != 4 || history[0].Commit != "0" || history[3].Commit != "3" {
+		t.Log("Expected the whole history, oldest first, got", history, err)
+		t.Fail()
+	}
+	history, _ = s.History("github.com/user/repo:golang-1.10", start.AddDate(0, 0, 1), start.AddDate(0, 0, 2), 10)
+	if len(history) != 2 || history[0].Commit != "1" || history[1].Commit != "2" {
+		t.Log("Expected the history between day 1 and 2, got", history)
+		t.Fail()
+	}
+	history, _ = s.History("github.com/user/repo:golang-1.10", time.Time{}, time.Time{}, 2)
+	if len(history) != 2 || history[0].Commit != "2" || history[1].Commit != "3" {
+		t.Log("Expected the 2 most recent results, got", history)
+		t.Fail()
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, newMemoryStore())
+
+	// the expired items are dropped when read, and by a sweep at most every
+	// memorySweepInterval
+	ms := newMemoryStore()
+	ms.Set("read", "value", time.Nanosecond)
+	ms.Set("unread", "value", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	str := ""
+	if err := ms.Get("read", &str); err != ErrNotFound {
+		t.Log("Expected", ErrNotFound, "for the expired key, got", err, str)
+		t.Fail()
+	}
+	ms.Set("key", "value", 0)
+	if _, ok := ms.items["read"]; ok || len(ms.items) != 2 {
+		t.Log("Expected only the expired key read to be dropped, got", ms.items)
+		t.Fail()
+	}
+
+	ms.swept = time.Now().Add(-memorySweepInterval)
+	ms.Set("key", "value", 0)
+	if _, ok := ms.items["unread"]; ok || len(ms.items) != 1 {
+		t.Log("Expected the expired keys to be swept, got", ms.items)
+		t.Fail()
+	}
+}
+
+// testRedisAddr returns the address of the Redis server of the tests, set with REDIS_ADDR,
+// after flushing it. The test is skipped if it is not set.
+func testRedisAddr(t *testing.T) string {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+	client := newRedisClient(addr)
+	defer client.Close()
+	err := client.FlushDB().Err()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return addr
+}
+
+func TestRedisStore(t *testing.T) {
+	testStore(t, newRedisStore(testRedisAddr(t)))
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cover")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cover.db")
+	ds, err := newDiskStore(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	testStore(t, ds)
+	ds.Close()
+
+	// an incomplete record at the end is dropped
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	ds, err = newDiskStore(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	obj := &Object{}
+	err = ds.Get("github.com/user/repo:golang-1.10", obj)
+	if err != nil || obj.Cover != "10.00%" {
+		t.Log("Expected the object to be loaded from disk, got", obj, err)
+		t.Fail()
+	}
+
+	str := ""
+	err = ds.Get("deleted", &str)
+	if err != ErrNotFound {
+		t.Log("Expected the deleted key to stay deleted, got", err, str)
+		t.Fail()
+	}
+
+	keys, _ := ds.Latest(5)
+	if len(keys) != 3 || keys[0] != "a" {
+		t.Log("Expected the latest keys to be loaded from disk, got", keys)
+		t.Fail()
+	}
+
+	for i := 0; i < diskCompactMin; i++ {
+		ds.Set("github.com/user/repo:golang-1.10", &Object{Cover: "20.00%"}, 0)
+	}
+	if ds.records > diskCompactMin {
+		t.Log("Expected the log to be compacted, got", ds.records, "records")
+		t.Fail()
+	}
+	err = ds.Set("compacted", "after", 0)
+	if err != nil {
+		t.Log("Expected a set after compaction to be saved, got", err)
+		t.Fail()
+	}
+	ds.Close()
+
+	ds, err = newDiskStore(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	err = ds.Get("github.com/user/repo:golang-1.10", obj)
+	if err != nil || obj.Cover != "20.00%" {
+		t.Log("Expected the object to survive compaction, got", obj, err)
+		t.Fail()
+	}
+	if err = ds.Get("compacted", &str); err != nil || str != "after" {
+		t.Log("Expected the set after compaction to be loaded from disk, got", str, err)
+		t.Fail()
+	}
+	keys, _ = ds.Latest(5)
+	if len(keys) != 3 || keys[0] != "a" || keys[2] != "b" {
+		t.Log("Expected the latest keys to survive compaction, got", keys)
+		t.Fail()
+	}
+	history, _ := ds.History("github.com/user/repo:golang-1.10", time.Time{}, time.Time{}, 10)
+	if len(history) != 4 || history[0].Commit != "0" {
+		t.Log("Expected the history to survive compaction, got", history)
+		t.Fail()
+	}
+	ds.Close()
+
+	// a corrupted size is dropped without allocating it
+	if _, _, err := readDiskRecord(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0})); err != ErrDiskRecordSize {
+		t.Log("Expected", ErrDiskRecordSize, "got", err)
+		t.Fail()
+	}
+	f, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0})
+	f.Close()
+
+	ds, err = newDiskStore(path)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer ds.Close()
+	if err = ds.Get("github.com/user/repo:golang-1.10", obj); err != nil || obj.Cover != "20.00%" {
+		t.Log("Expected the records before the corrupted one to be loaded, got", obj, err)
+		t.Fail()
+	}
+}
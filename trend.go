@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Trend badge templates, the sparkline is drawn between the label and the status
+	curveTrendBadge = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient><clipPath id="a"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0h{{.LabelWidth}}v20H0z"/><path fill="#444" d="M{{.LabelWidth}} 0h{{.TrendWidth}}v20H{{.LabelWidth}}z"/><path fill="{{.Color}}" d="M{{.StatusStart}} 0h{{.StatusWidth}}v20H{{.StatusStart}}z"/>{{if .Points}}<polyline fill="none" stroke="{{.Color}}" stroke-width="1.5" stroke-linecap="round" stroke-linejoin="round" points="{{.Points}}"/>{{end}}<path fill="url(#b)" d="M0 0h{{.Width}}v20H0z"/></g>{{if .Logo}}<image x="{{.LogoX}}" y="3" width="{{.LogoWidth}}" height="14" xlink:href="{{.Logo}}"/>{{end}}<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="{{.LabelX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.LabelX}}" y="140" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.StatusX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text><text x="{{.StatusX}}" y="140" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text></g> </svg>`
+	flatTrendBadge  = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="20"><g shape-rendering="crispEdges"><path fill="#555" d="M0 0h{{.LabelWidth}}v20H0z"/><path fill="#444" d="M{{.LabelWidth}} 0h{{.TrendWidth}}v20H{{.LabelWidth}}z"/><path fill="{{.Color}}" d="M{{.StatusStart}} 0h{{.StatusWidth}}v20H{{.StatusStart}}z"/></g>{{if .Points}}<polyline fill="none" stroke="{{.Color}}" stroke-width="1.5" stroke-linecap="round" stroke-linejoin="round" points="{{.Points}}"/>{{end}}{{if .Logo}}<image x="{{.LogoX}}" y="3" width="{{.LogoWidth}}" height="14" xlink:href="{{.Logo}}"/>{{end}}<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110"><text x="{{.LabelX}}" y="140" transform="scale(.1)" textLength="{{.LabelLength}}">{{html .Label}}</text><text x="{{.StatusX}}" y="140" transform="scale(.1)" textLength="{{.StatusLength}}">{{html .Status}}</text></g> </svg>`
+
+	// trendWidth is the width of the sparkline area, trendPadding its padding
+	trendWidth   = 50
+	trendPadding = 3
+	// trendMinSpan is the smallest coverage span drawn over the height of the sparkline, so
+	// tiny changes are not shown as large swings
+	trendMinSpan = 1.0
+	// trendPoints is the number of results drawn if no `n` is given, trendPointsMax the
+	// maximum
+	trendPoints    = 10
+	trendPointsMax = 50
+)
+
+var (
+	curveTrendBadgeTmpl *template.Template
+	flatTrendBadgeTmpl  *template.Template
+)
+
+func init() {
+	curveTrendBadgeTmpl = template.Must(template.New("").Parse(curveTrendBadge))
+	flatTrendBadgeTmpl = template.Must(template.New("").Parse(flatTrendBadge))
+}
+
+type trendBadge struct {
+	badge
+	TrendWidth  int
+	StatusStart int
+	// Points are the points of the sparkline polyline
+	Points string
+}
+
+// sparkline returns the polyline points of the values, drawn in a box of the given width
+// and height starting at x. The values are scaled between their minimum and maximum.
+func sparkline(values []float64, x, width, height, padding int) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if span := max - min; span < trendMinSpan {
+		mid := (max + min) / 2
+		min, max = mid-trendMinSpan/2, mid+trendMinSpan/2
+	}
+
+	top, bottom := float64(padding), float64(height-padding)
+	left, right := float64(x+padding), float64(x+width-padding)
+
+	// a single result is drawn as a flat line
+	if len(values) == 1 {
+		values = []float64{values[0], values[0]}
+	}
+	step := (right - left) / float64(len(values)-1)
+
+	points := make([]string, len(values))
+	for i, v := range values {
+		px := left + float64(i)*step
+		py := bottom - (v-min)/(max-min)*(bottom-top)
+		points[i] = fmt.Sprintf("%.1f,%.1f", px, py)
+	}
+	return strings.Join(points, " ")
+}
+
+// getTrendBadge returns the SVG badge with the label, the status and a sparkline of the values,
+// and the logo before the label if not nil
+func getTrendBadge(label, color, style, status string, values []float64, logo *badgeLogo) string {
+	buf := new(bytes.Buffer)
+
+	b := &trendBadge{
+		badge: badge{
+			Label:  label,
+			Status: status,
+			Color:  badgeColor(color),
+		},
+		TrendWidth: trendWidth,
+	}
+	b.setLogo(logo, style)
+	b.layout()
+	// the sparkline is drawn between the label and the status
+	b.StatusStart = b.LabelWidth + trendWidth
+	b.Points = sparkline(values, b.LabelWidth, trendWidth, 20, trendPadding)
+	b.StatusX += trendWidth * 10
+	b.Width += trendWidth
+
+	if curvedStyle(style) {
+		curveTrendBadgeTmpl.Execute(buf, b)
+	} else {
+		flatTrendBadgeTmpl.Execute(buf, b)
+	}
+	return buf.String()
+}
+
+// trendBadgeSVG returns the SVG badge with the current coverage of a repository and a
+// sparkline of its last n results
+func trendBadgeSVG(repo, tag, ref, style string, n int, opts *badgeOptions) string {
+	obj, color, status := coverageStatus(repo, tag, ref, opts.Thresholds)
+
+	values := make([]float64, 0, n)
+	history, err := repoHistory(repo, obj.Tag, ref, time.Time{}, time.Time{}, n)
+	if err != nil {
+		errLogger.Println(err)
+	}
+	for _, entry := range history {
+		values = append(values, entry.Percent)
+	}
+
+	return getTrendBadge(opts.label(), opts.color(color), style, status, values, opts.Logo)
+}
+
+// HandlerRepoTrend returns the SVG badge with the coverage of a repository and a sparkline
+// of its last `n` results, customized with `label`, `color` and `thresholds` as the coverage
+// badge
+func HandlerRepoTrend(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+	if tag == "" {
+		tag = DefaultTag
+	}
+	repo := strings.TrimSpace(mux.Vars(r)["repo"])
+
+	badgeStyle := parseBadgeStyle(r.URL.Query().Get("style"))
+
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+
+	n, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get("n")))
+	if err != nil || n < 1 {
+		n = trendPoints
+	}
+	if n > trendPointsMax {
+		n = trendPointsMax
+	}
+
+	opts, err := parseBadgeOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	svg := trendBadgeSVG(repo, tag, ref, badgeStyle, n, opts)
+
+	w.Header().Set("cache-control", "priviate, max-age=0, no-cache")
+	w.Header().Set("pragma", "no-cache")
+	w.Header().Set("expires", "-1")
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	w.Write([]byte(svg))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSparkline(t *testing.T) {
+	points := sparkline([]float64{10, 20, 15}, 61, 50, 20, 3)
+	if points != "64.0,17.0 86.0,3.0 108.0,10.0" {
+		t.Log("Unexpected points", points)
+		t.Fail()
+	}
+
+	points = sparkline([]float64{50}, 61, 50, 20, 3)
+	if points != "64.0,10.0 108.0,10.0" {
+		t.Log("Expected a flat line, got", points)
+		t.Fail()
+	}
+
+	if points = sparkline(nil, 61, 50, 20, 3); points != "" {
+		t.Log("Expected no points, got", points)
+		t.Fail()
+	}
+}
+
+func TestHandlerRepoTrend(t *testing.T) {
+	oldStore := store
+	defer func() { store = oldStore }()
+	store = newMemoryStore()
+
+	for _, covered := range []int{4, 6} {
+		saveCover(&Object{
+			Repo:     "github.com/user/repo",
+			Tag:      "golang-1.10",
+			Cover:    fmt.Sprintf("%d.00%%", covered*10),
+			Output:   true,
+			Packages: []PackageCoverage{{Name: "github.com/user/repo", Statements: 10, Covered: covered}},
+		}, nil)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/go/{repo:.*}/trend.svg", HandlerRepoTrend)
+	r.HandleFunc("/go/{repo:.*}.svg", HandlerRepoSVG)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/trend.svg?tag=1.10", nil))
+	svg := rec.Body.String()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Log("Expected an SVG badge, got", rec.Code, rec.Header())
+		t.Fail()
+	}
+	if !strings.Contains(svg, "<polyline") || !strings.Contains(svg, ">60.00%<") || !strings.Contains(svg, "crispEdges") {
+		t.Log("Expected a flat-square sparkline badge, got", svg)
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/go/github.com/user/repo/trend.svg?tag=1.10&style=flat", nil))
+	if svg = rec.Body.String(); !strings.Contains(svg, "<polyline") || !strings.Contains(svg, "clipPath") {
+		t.Log("Expected a flat sparkline badge, got", svg)
+		t.Fail()
+	}
+}
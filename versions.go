@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gofn/gofn/provision"
+)
+
+const (
+	// imageRepo is the Docker repository of the images used to run cover
+	imageRepo = "avelino/cover.run"
+	// versionPrefix is the prefix of the Docker image tags and the canonical version names
+	versionPrefix = "golang-"
+	// latestAlias is the alias of the most recent supported Go version
+	latestAlias = "latest"
+)
+
+// defaultVersions are the Go versions supported when none are configured or discovered,
+// they match the images built by build.sh
+var defaultVersions = []string{"1.11", "1.10", "1.9", "1.8"}
+
+// GoVersion is a Go version cover can be run with
+type GoVersion struct {
+	// Name is the canonical name of the version, e.g. golang-1.11
+	Name string
+	// Version is the Go version, e.g. 1.11
+	Version string
+	// Image is the Docker image used to run cover
+	Image string
+	// Aliases are all the names the version can be requested with
+	Aliases []string
+	// Limits are the configured container limits of the version, if any
+	Limits *ContainerLimits `json:"-"`
+}
+
+// versionRegistry holds the supported Go versions
+type versionRegistry struct {
+	mu       sync.RWMutex
+	versions []*GoVersion
+	aliases  map[string]*GoVersion
+}
+
+// versions is the registry of the supported Go versions
+var versions = newVersionRegistry(versionConfigs(defaultVersions))
+
+// versionConfigs returns the configuration of the given Go versions with default settings
+func versionConfigs(list []string) []VersionConfig {
+	cfgs := make([]VersionConfig, 0, len(list))
+	for _, v := range list {
+		cfgs = append(cfgs, VersionConfig{Version: v})
+	}
+	return cfgs
+}
+
+// newVersionRegistry returns a registry with the given versions
+func newVersionRegistry(cfgs []VersionConfig) *versionRegistry {
+	vr := &versionRegistry{}
+	vr.set(cfgs)
+	return vr
+}
+
+// normalizeVersion returns the bare Go version of a name, e.g. "1.11" for "go1.11" or
+// "golang-1.11"
+func normalizeVersion(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimPrefix(name, versionPrefix)
+	name = strings.TrimPrefix(name, "go")
+	return name
+}
+
+// compareVersions compares two Go versions numerically, e.g. 1.10 is greater than 1.9
+func compareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		na, nb := 0, 0
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// set replaces the versions of the registry
+func (vr *versionRegistry) set(cfgs []VersionConfig) {
+	list := make([]*GoVersion, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		version := normalizeVersion(cfg.Version)
+		if version == "" {
+			continue
+		}
+
+		v := &GoVersion{
+			Name:    versionPrefix + version,
+			Version: version,
+			Image:   cfg.Image,
+			Limits:  cfg.Limits,
+			Aliases: []string{version, "go" + version, versionPrefix + version},
+		}
+		if v.Image == "" {
+			v.Image = fmt.Sprintf("%s:%s", imageRepo, v.Name)
+		}
+		for _, alias := range cfg.Aliases {
+			v.Aliases = append(v.Aliases, strings.ToLower(strings.TrimSpace(alias)))
+		}
+		list = append(list, v)
+	}
+
+	// newest first
+	sort.SliceStable(list, func(i, j int) bool {
+		return compareVersions(list[i].Version, list[j].Version) > 0
+	})
+
+	aliases := make(map[string]*GoVersion)
+	for _, v := range list {
+		for _, alias := range v.Aliases {
+			if _, ok := aliases[alias]; !ok {
+				aliases[alias] = v
+			}
+		}
+	}
+	if len(list) > 0 {
+		if _, ok := aliases[latestAlias]; !ok {
+			aliases[latestAlias] = list[0]
+			list[0].Aliases = append(list[0].Aliases, latestAlias)
+		}
+	}
+
+	vr.mu.Lock()
+	vr.versions = list
+	vr.aliases = aliases
+	vr.mu.Unlock()
+}
+
+// Resolve returns the Go version for the given name or alias
+func (vr *versionRegistry) Resolve(name string) (*GoVersion, bool) {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	v, ok := vr.aliases[strings.ToLower(strings.TrimSpace(name))]
+	return v, ok
+}
+
+// List returns all the supported Go versions, newest first
+func (vr *versionRegistry) List() []*GoVersion {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	list := make([]*GoVersion, len(vr.versions))
+	copy(list, vr.versions)
+	return list
+}
+
+// discoverVersions returns the Go versions of the local avelino/cover.run:golang-* images
+func discoverVersions() ([]VersionConfig, error) {
+	client, err := provision.FnClient("")
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := client.ListImages(docker.ListImagesOptions{
+		Filters: map[string][]string{
+			"reference": {imageRepo + ":" + versionPrefix + "*"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cfgs := make([]VersionConfig, 0)
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			if !strings.HasPrefix(tag, imageRepo+":"+versionPrefix) {
+				continue
+			}
+			cfgs = append(cfgs, VersionConfig{
+				Version: strings.TrimPrefix(tag, imageRepo+":"+versionPrefix),
+				Image:   tag,
+			})
+		}
+	}
+	return cfgs, nil
+}
+
+// loadVersions sets the supported Go versions from the configuration, or the local Docker
+// images if none are configured. The default versions are kept if neither has any.
+func loadVersions(cfg *Config) {
+	if len(cfg.Versions) > 0 {
+		versions.set(cfg.Versions)
+		return
+	}
+
+	cfgs, err := discoverVersions()
+	if err != nil {
+		errLogger.Println(err)
+		return
+	}
+	if len(cfgs) == 0 {
+		errLogger.Println("no", imageRepo, "images found, using the default Go versions")
+		return
+	}
+	versions.set(cfgs)
+}
+
+// langVersionSupported returns true if the given Go version is supported
+func langVersionSupported(version string) bool {
+	_, ok := versions.Resolve(version)
+	return ok
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestVersionRegistry(t *testing.T) {
+	vr := newVersionRegistry([]VersionConfig{
+		{Version: "1.9"},
+		{Version: "go1.10"},
+		{Version: "1.11", Image: "example/go:1.11", Aliases: []string{"Stable"}},
+	})
+
+	tt := map[string]string{
+		"1.11":        "golang-1.11",
+		"go1.11":      "golang-1.11",
+		"golang-1.11": "golang-1.11",
+		"stable":      "golang-1.11",
+		"latest":      "golang-1.11",
+		" Go1.10 ":    "golang-1.10",
+		"1.9":         "golang-1.9",
+	}
+	for name, expected := range tt {
+		v, ok := vr.Resolve(name)
+		if !ok || v.Name != expected {
+			t.Log(name, "expected", expected, "got", v, ok)
+			t.Fail()
+		}
+	}
+
+	if _, ok := vr.Resolve("1.8"); ok {
+		t.Log("1.8 should not be supported")
+		t.Fail()
+	}
+
+	list := vr.List()
+	if len(list) != 3 || list[0].Version != "1.11" || list[1].Version != "1.10" || list[2].Version != "1.9" {
+		t.Log("Expected versions sorted newest first, got", list)
+		t.Fail()
+	}
+
+	if list[0].Image != "example/go:1.11" || list[1].Image != "avelino/cover.run:golang-1.10" {
+		t.Log("Unexpected images", list[0].Image, list[1].Image)
+		t.Fail()
+	}
+
+	if !langVersionSupported(DefaultTag) {
+		t.Log("Default tag", DefaultTag, "should be supported")
+		t.Fail()
+	}
+}